@@ -0,0 +1,173 @@
+/*
+
+access.go
+
+Support for -access-log, which counts the hits of each client IP address in a web server access log
+and outputs their geolocation, most hits first. Common Log Format, Combined Log Format, Apache's
+vhost_combined and JSON logs, such as nginx with escape=json, are detected per line.
+With -log-report, the hits are summarized per country and org instead.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The JSON keys that hold the client address, in order of preference
+var accessLogIPKeys = []string{"remote_addr", "client_ip", "clientip", "remote_ip", "ip"}
+
+/*
+accessLogIP returns the client IP address of a single access log line
+
+Args:
+
+	line: a line from the log
+
+Returns:
+
+	the IP address, or an empty string if the line does not contain one
+*/
+func accessLogIP(line string) string {
+	if strings.HasPrefix(line, "{") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return ""
+		}
+		for _, key := range accessLogIPKeys {
+			if value, ok := record[key].(string); ok && net.ParseIP(value) != nil {
+				return value
+			}
+		}
+		return ""
+	}
+	// the client is the first field, or the second with vhost_combined, which starts with host:port
+	fields := strings.SplitN(line, " ", 3)
+	for i := 0; i < len(fields) && i < 2; i++ {
+		if net.ParseIP(fields[i]) != nil {
+			return fields[i]
+		}
+	}
+	return ""
+}
+
+/*
+countAccessLog counts the hits of each client IP address
+
+Args:
+
+	r: the access log
+
+Returns:
+
+	a map where key=IP address, value=number of hits
+
+	the IP addresses in the order they first appear
+*/
+func countAccessLog(r io.Reader) (map[string]int, []string, error) {
+	hits := make(map[string]int)
+	var ipAddrs []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		ip := accessLogIP(scanner.Text())
+		if len(ip) == 0 {
+			continue
+		}
+		if hits[ip] == 0 {
+			ipAddrs = append(ipAddrs, ip)
+		}
+		hits[ip]++
+	}
+	return hits, ipAddrs, scanner.Err()
+}
+
+/*
+runAccessLog reads a web server access log, looks up all public client addresses, and outputs
+the hits per IP address, or per country and org with report
+
+Args:
+
+	fname: the log file name, or - for standard input
+
+	report: output a summary of hits per country and org instead of per IP address
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an error if the log can not be read
+*/
+func runAccessLog(fname string, report bool, workers int, cache ipCache) error {
+	input := os.Stdin
+	if fname != "-" {
+		file, err := os.Open(fname)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	hits, ipAddrs, err := countAccessLog(input)
+	if err != nil {
+		return err
+	}
+	if len(ipAddrs) == 0 {
+		return fmt.Errorf("no client IP addresses found in %s", fname)
+	}
+
+	var public []string
+	for _, ip := range ipAddrs {
+		if isPublicIP(ip) {
+			public = append(public, ip)
+		}
+	}
+	infos := make(map[string]ipInfoResult)
+	for _, info := range resolveAllIpInfo(workers, public, cache) {
+		infos[info.Ip] = info
+	}
+
+	if report {
+		counts := make(map[[2]string]int)
+		for ip, count := range hits {
+			key := [2]string{"N/A", "N/A"}
+			if info, ok := infos[ip]; ok && len(info.Country) > 0 {
+				key = [2]string{info.Country, orNA(info.Org)}
+			}
+			counts[key] += count
+		}
+		outputLogReport(counts, "Hits")
+		return nil
+	}
+
+	sort.SliceStable(ipAddrs, func(a, b int) bool {
+		return hits[ipAddrs[a]] > hits[ipAddrs[b]]
+	})
+	total := 0
+	for _, count := range hits {
+		total += count
+	}
+	var allRows [][]string
+	for _, ip := range ipAddrs {
+		info := infos[ip]
+		share := fmt.Sprintf("%.1f%%", 100*float64(hits[ip])/float64(total))
+		allRows = append(allRows, []string{strconv.Itoa(hits[ip]), share, ip, orNA(info.Hostname), orNA(info.Org), orNA(info.City), orNA(info.Region), orNA(info.Country)})
+	}
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"Hits", "Share", "IP", "Hostname", "Org", "City", "Region", "Country"})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+
+aggregate.go
+
+Support for -aggregate, which collapses the results into covering network prefixes, such as /24 for IPv4
+and /48 for IPv6. Each prefix is output once with the number of addresses found in it and the location
+shared by most of them, so that long lists of addresses become readable and usable in firewall rules.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A network prefix covering one or more results
+type prefixSummary struct {
+	Prefix    string   `json:"prefix"`
+	Addresses int      `json:"addresses"`
+	Inputs    []string `json:"inputs"`
+	Org       string   `json:"org"`
+	City      string   `json:"city"`
+	Region    string   `json:"region"`
+	Country   string   `json:"country"`
+	Loc       string   `json:"loc"`
+}
+
+/*
+parseAggregate converts the value of -aggregate into prefix lengths
+
+Args:
+
+	spec: an IPv4 prefix length optionally followed by an IPv6 prefix length, such as: /24 or /24,/48
+
+Returns:
+
+	the IPv4 prefix length
+
+	the IPv6 prefix length, 48 when not given
+*/
+func parseAggregate(spec string) (int, int, error) {
+	bits := []int{0, 48}
+	parts := strings.Split(spec, ",")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("invalid -aggregate value: %s", spec)
+	}
+	for i, part := range parts {
+		length, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(part), "/"))
+		if err != nil || length < 0 || (i == 0 && length > 32) || length > 128 {
+			return 0, 0, fmt.Errorf("invalid -aggregate prefix length: %s", part)
+		}
+		bits[i] = length
+	}
+	return bits[0], bits[1], nil
+}
+
+/*
+aggregateRows collapses rows into the network prefixes that cover them
+
+Args:
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	v4bits: the prefix length of IPv4 networks
+
+	v6bits: the prefix length of IPv6 networks
+
+Returns:
+
+	a slice of prefixSummary, sorted by prefix; the location is the one shared by most addresses of a prefix
+*/
+func aggregateRows(rows []resultRow, v4bits, v6bits int) []prefixSummary {
+	type location struct {
+		org, city, region, country, loc string
+	}
+	var order []string
+	summaries := make(map[string]*prefixSummary)
+	addresses := make(map[string][]string)
+	votes := make(map[string]map[location]int)
+	for _, row := range rows {
+		prefix := networkOf(row.Ip, v4bits, v6bits)
+		if len(prefix) == 0 {
+			continue
+		}
+		summary, ok := summaries[prefix]
+		if !ok {
+			summary = &prefixSummary{Prefix: prefix}
+			summaries[prefix] = summary
+			votes[prefix] = make(map[location]int)
+			order = append(order, prefix)
+		}
+		if !stringInSlice(row.Input, summary.Inputs) {
+			summary.Inputs = append(summary.Inputs, row.Input)
+		}
+		if stringInSlice(row.Ip, addresses[prefix]) {
+			continue
+		}
+		addresses[prefix] = append(addresses[prefix], row.Ip)
+		summary.Addresses++
+
+		current := location{row.Org, row.City, row.Region, row.Country, row.Loc}
+		votes[prefix][current]++
+		best := location{summary.Org, summary.City, summary.Region, summary.Country, summary.Loc}
+		if votes[prefix][current] > votes[prefix][best] {
+			summary.Org, summary.City, summary.Region, summary.Country, summary.Loc = current.org, current.city, current.region, current.country, current.loc
+		}
+	}
+
+	sort.Slice(order, func(a, b int) bool {
+		ipA, netA, _ := net.ParseCIDR(order[a])
+		ipB, netB, _ := net.ParseCIDR(order[b])
+		if c := bytes.Compare(ipA.To16(), ipB.To16()); c != 0 {
+			return c < 0
+		}
+		return netA.String() < netB.String()
+	})
+	var result []prefixSummary
+	for _, prefix := range order {
+		result = append(result, *summaries[prefix])
+	}
+	return result
+}
+
+/*
+outputAggregate outputs one row per network prefix
+
+Args:
+
+	w: where to write the output to
+
+	summaries: a slice as returned by aggregateRows
+
+	format: table, json or tsv
+
+	wrap: wrap output to better fit the screen width
+
+Returns:
+
+	an error if the output could not be written
+*/
+func outputAggregate(w io.Writer, summaries []prefixSummary, format string, wrap bool) error {
+	if format == "json" {
+		if summaries == nil {
+			summaries = []prefixSummary{}
+		}
+		return writeJSON(w, summaries)
+	}
+
+	header := []string{"Prefix", "Addresses", "Inputs", "Org", "City", "Region", "Country", "Loc"}
+	var allRows [][]string
+	for _, s := range summaries {
+		allRows = append(allRows, []string{s.Prefix, strconv.Itoa(s.Addresses), strings.Join(s.Inputs, ", "), s.Org, orNA(s.City), orNA(s.Region), s.Country, orNA(s.Loc)})
+	}
+	return outputSummaryCells(w, header, allRows, format, wrap)
+}
+
+/*
+outputSummaryCells outputs the cells of a summary, such as -aggregate or -group-by, as a table or as tab separated values
+
+Args:
+
+	w: where to write the output to
+
+	header: the column names
+
+	allRows: the cells of each row
+
+	format: table or tsv
+
+	wrap: wrap output to better fit the screen width
+
+Returns:
+
+	an error if the output could not be written
+*/
+func outputSummaryCells(w io.Writer, header []string, allRows [][]string, format string, wrap bool) error {
+	if format == "tsv" {
+		for _, row := range append([][]string{header}, allRows...) {
+			if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table := newTable(w)
+	table.SetHeader(header)
+	table.SetAutoWrapText(wrap)
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+
+aliases.go
+
+Support for an aliases file which maps a short name to a group of targets, for example:
+
+	# name: targets separated by commas and/or spaces
+	prod: lb1.example.com, lb2.example.com
+
+A command line argument of @prod is then expanded to lb1.example.com and lb2.example.com. Target sets
+managed with the targets subcommand are expanded the same way; see targets.go.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const aliasesFileName string = ".ipinfo_aliases"
+
+/*
+defaultAliasesFile returns the location of the aliases file in the user's home directory
+
+Returns:
+
+	the full path name, or just the file name when the home directory can not be determined
+*/
+func defaultAliasesFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return aliasesFileName
+	}
+	return filepath.Join(home, aliasesFileName)
+}
+
+/*
+loadAliases reads an aliases file; blank lines and lines starting with # are ignored
+
+Args:
+
+	fname: the aliases file name
+
+Returns:
+
+	a map where key=alias name, value=a slice of targets
+*/
+func loadAliases(fname string) (map[string][]string, error) {
+	aliases := make(map[string][]string)
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		slots := strings.SplitN(line, ":", 2)
+		if len(slots) != 2 || len(strings.TrimSpace(slots[0])) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected \"name: target, target, ...\"", fname, lineNum)
+		}
+		name := strings.TrimSpace(slots[0])
+		targets := strings.FieldsFunc(slots[1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		aliases[name] = append(aliases[name], targets...)
+	}
+	return aliases, scanner.Err()
+}
+
+/*
+expandAliases replaces each argument starting with @ with the targets of that target set or alias;
+target sets managed with the targets subcommand take precedence over the aliases file
+
+Args:
+
+	args: the command line arguments
+
+	fname: the aliases file name, which is only read when an argument starts with @
+
+	history: the -history value, which selects where target sets are stored
+
+Returns:
+
+	args with all aliases expanded
+*/
+func expandAliases(args []string, fname string, history string) ([]string, error) {
+	var sets, aliases map[string][]string
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		if sets == nil {
+			store, err := openTargetSets(history)
+			if err == nil {
+				sets, err = store.load()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if targets, ok := sets[arg[1:]]; ok {
+			expanded = append(expanded, targets...)
+			continue
+		}
+		if aliases == nil {
+			var err error
+			aliases, err = loadAliases(fname)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		targets, ok := aliases[arg[1:]]
+		if !ok {
+			return nil, fmt.Errorf("target set or alias not found: %s", arg[1:])
+		}
+		expanded = append(expanded, targets...)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,212 @@
+/*
+
+audit.go
+
+An append-only audit log of every lookup made through the serve and bot subcommands, selected with -audit.
+Each line is a JSON object recording who asked, when, for which targets, and for each IP address the
+provider that answered and whether the answer came from cache:
+
+	{"time":"2026-10-16T14:03:11Z","mode":"serve","who":"security","remote":"10.1.2.3:51234","targets":["example.com"],
+	 "lookups":[{"ip":"93.184.215.14","provider":"ipinfo.io","cached":true}]}
+
+When the log reaches -audit-max-size megabytes, it is renamed to file.1, file.1 to file.2 and so on,
+keeping -audit-keep old logs.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The lookup of a single IP address in an audit entry
+type auditLookup struct {
+	Ip       string `json:"ip"`
+	Provider string `json:"provider"`
+	Cached   bool   `json:"cached"`
+}
+
+// A single line of the audit log
+type auditEntry struct {
+	Time    time.Time     `json:"time"`
+	Mode    string        `json:"mode"`
+	Who     string        `json:"who"`
+	Remote  string        `json:"remote,omitempty"`
+	Targets []string      `json:"targets"`
+	Lookups []auditLookup `json:"lookups"`
+}
+
+// An append-only JSON lines file with size based rotation
+type auditLog struct {
+	fname   string
+	maxSize int64
+	keep    int
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+/*
+openAuditLog opens the audit log for appending
+
+Args:
+
+	fname: the file name; when empty, no audit log is kept
+
+	maxSizeMB: the size in megabytes at which the log is rotated, or 0 to never rotate
+
+	keep: the number of rotated logs to keep
+
+Returns:
+
+	a pointer to an auditLog struct, or nil when fname is empty; all methods accept nil
+*/
+func openAuditLog(fname string, maxSizeMB int, keep int) (*auditLog, error) {
+	if len(fname) == 0 {
+		return nil, nil
+	}
+	audit := &auditLog{fname: fname, maxSize: int64(maxSizeMB) * 1024 * 1024, keep: keep}
+	if err := audit.open(); err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+/*
+addAuditFlags adds the -audit, -audit-max-size and -audit-keep flags to the flags of a subcommand
+
+Args:
+
+	flags: the flags of the subcommand
+
+Returns:
+
+	a function that opens the audit log given by the flags, to be called after the flags are parsed
+*/
+func addAuditFlags(flags *flag.FlagSet) func() (*auditLog, error) {
+	fname := flags.String("audit", envString("IPINFO_AUDIT", ""), "append a JSON line for every lookup to this audit log; env: IPINFO_AUDIT")
+	maxSize := flags.Int("audit-max-size", envInt("IPINFO_AUDIT_MAX_SIZE", 100), "rotate the audit log at this size in megabytes, 0 to never rotate; env: IPINFO_AUDIT_MAX_SIZE")
+	keep := flags.Int("audit-keep", envInt("IPINFO_AUDIT_KEEP", 10), "number of rotated audit logs to keep; env: IPINFO_AUDIT_KEEP")
+	return func() (*auditLog, error) {
+		return openAuditLog(*fname, *maxSize, *keep)
+	}
+}
+
+// open opens the log file and reads its current size; the caller must hold a.mu or be the only user
+func (a *auditLog) open() error {
+	file, err := os.OpenFile(a.fname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file, a.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the log to fname.1, shifting older logs and removing the oldest; the caller must hold a.mu
+func (a *auditLog) rotate() error {
+	a.file.Close()
+	if a.keep > 0 {
+		for i := a.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", a.fname, i), fmt.Sprintf("%s.%d", a.fname, i+1))
+		}
+		if err := os.Rename(a.fname, a.fname+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(a.fname); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+/*
+cachedBefore reports which IP addresses are in cache before they are looked up
+
+Args:
+
+	cache: the cache used for the lookups, or nil
+
+	ipAddrs: the IP addresses about to be looked up
+
+Returns:
+
+	the IP addresses with a cache entry that will be used without calling ipinfo.io; nil when a is nil
+*/
+func (a *auditLog) cachedBefore(cache ipCache, ipAddrs []string) map[string]bool {
+	if a == nil || cache == nil {
+		return nil
+	}
+	cached := make(map[string]bool)
+	for _, ip := range ipAddrs {
+		shared := cache
+		if memory, ok := cache.(*memoryCache); ok {
+			if memory.contains(ip) {
+				cached[ip] = true
+				continue
+			}
+			if shared = memory.inner; shared == nil {
+				continue
+			}
+		}
+		if entry, found := shared.get(ip); found && !shared.refresh() && time.Now().Before(entry.Expires) {
+			cached[ip] = true
+		}
+	}
+	return cached
+}
+
+/*
+record appends an entry to the audit log; errors are output to stderr, so that a full disk does not stop lookups
+
+Args:
+
+	mode: serve or bot
+
+	who: the API key team or chat user
+
+	remote: the client address, or empty
+
+	targets: the requested targets
+
+	ipAddrs: the IP addresses the targets resolved to
+
+	cached: as returned by cachedBefore
+*/
+func (a *auditLog) record(mode string, who string, remote string, targets []string, ipAddrs []string, cached map[string]bool) {
+	if a == nil {
+		return
+	}
+	entry := auditEntry{Time: time.Now().UTC(), Mode: mode, Who: who, Remote: remote, Targets: targets, Lookups: []auditLookup{}}
+	for _, ip := range ipAddrs {
+		entry.Lookups = append(entry.Lookups, auditLookup{Ip: ip, Provider: "ipinfo.io", Cached: cached[ip]})
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit error:", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line))+1 > a.maxSize {
+		if err := a.rotate(); err != nil {
+			fmt.Fprintln(os.Stderr, "audit error:", err)
+			return
+		}
+	}
+	n, err := a.file.Write(append(line, '\n'))
+	a.size += int64(n)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit error:", err)
+	}
+}
@@ -0,0 +1,452 @@
+/*
+
+auth.go
+
+API keys for the serve subcommand. When a keys file is given, every lookup needs a key, given as
+"Authorization: Bearer <key>" or "X-API-Key: <key>". Each line of the keys file contains a key,
+the name of the team it belongs to and its rate limit, in looked up IP addresses:
+
+	# key                              name       rate
+	3f9c0a1b6e7d4c2a8b5e9f1d0c7a6b4e   security   60/m
+	9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d   netops     1000/h
+
+The keys of a team share its rate limit, so they must all be given the same rate. A request for many
+targets is charged one lookup per IP address, so that a single request can not use more than a team's
+share of the ipinfo.io rate limit. Requests and lookups are counted per name
+and saved to the usage SQLite database, so that usage can be reported per team. Each team can see its
+own usage at GET /usage.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The number of IP addresses an API key may look up at once before its rate limit applies
+const apiKeyBurst = 10
+
+// An API key from the keys file
+type apiKey struct {
+	name string
+}
+
+// The usage of a single team
+type keyUsage struct {
+	Requests int       `json:"requests"`
+	Lookups  int       `json:"lookups"`
+	Rejected int       `json:"rejected"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// API key authentication, rate limits and usage accounting for the serve subcommand
+type keyring struct {
+	keysFile string
+	db       *sql.DB // the usage database; nil when usage is not saved
+	keys     map[string]apiKey
+	rates    map[string]time.Duration // the interval between the lookups of each team
+	mu       sync.Mutex
+	next     map[string]time.Time // the theoretical arrival time of the next lookup of each key
+	usage    map[string]*keyUsage
+	dirty    bool
+}
+
+const createUsageTable string = `CREATE TABLE IF NOT EXISTS key_usage (
+	name TEXT PRIMARY KEY,
+	requests INTEGER NOT NULL,
+	lookups INTEGER NOT NULL,
+	rejected INTEGER NOT NULL,
+	last_used TIMESTAMP NOT NULL
+)`
+
+/*
+loadKeyring reads the keys file and the usage database
+
+Args:
+
+	keysFile: the keys file name; see the description at the top of this file
+
+	usageFile: the file name of the SQLite usage database, which is created when missing; an empty name disables saving usage
+
+Returns:
+
+	a pointer to a keyring struct
+*/
+func loadKeyring(keysFile string, usageFile string) (*keyring, error) {
+	keys, rates, err := readKeys(keysFile)
+	if err != nil {
+		return nil, err
+	}
+	ring := &keyring{keys: keys, rates: rates, keysFile: keysFile, next: make(map[string]time.Time), usage: make(map[string]*keyUsage)}
+	if len(usageFile) > 0 {
+		if ring.db, err = openUsage(usageFile, ring.usage); err != nil {
+			return nil, fmt.Errorf("%s: %w", usageFile, err)
+		}
+	}
+	return ring, nil
+}
+
+/*
+openUsage opens the SQLite usage database, creating its table when needed, and reads the saved usage
+
+Args:
+
+	usageFile: the database file name
+
+	usage: the map to read the usage of each team into
+
+Returns:
+
+	the database
+*/
+func openUsage(usageFile string, usage map[string]*keyUsage) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", usageFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createUsageTable); err != nil {
+		db.Close()
+		return nil, err
+	}
+	rows, err := db.Query("SELECT name, requests, lookups, rejected, last_used FROM key_usage")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var u keyUsage
+		if err := rows.Scan(&name, &u.Requests, &u.Lookups, &u.Rejected, &u.LastUsed); err != nil {
+			db.Close()
+			return nil, err
+		}
+		usage[name] = &u
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+/*
+readKeys reads the keys file
+
+Args:
+
+	keysFile: the keys file name; see the description at the top of this file
+
+Returns:
+
+	a map of key to apiKey struct
+
+	a map of team name to the interval between its lookups; an error is returned when the keys of a team
+	have different rates
+*/
+func readKeys(keysFile string) (map[string]apiKey, map[string]time.Duration, error) {
+	file, err := os.Open(keysFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]apiKey)
+	rates := make(map[string]time.Duration)
+	rateLines := make(map[string]int) // the line each team's rate was first given on
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, nil, fmt.Errorf("%s:%d: expected: key name rate", keysFile, lineNum)
+		}
+		interval, err := parseRate(fields[2])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", keysFile, lineNum, err)
+		}
+		name := fields[1]
+		if line, ok := rateLines[name]; ok && rates[name] != interval {
+			return nil, nil, fmt.Errorf("%s:%d: the rate of %s differs from line %d", keysFile, lineNum, name, line)
+		} else if !ok {
+			rates[name], rateLines[name] = interval, lineNum
+		}
+		keys[fields[0]] = apiKey{name: name}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no API keys found in %s", keysFile)
+	}
+	return keys, rates, nil
+}
+
+/*
+reload reads the keys file again, so that keys can be rotated without a restart; usage is kept.
+When the keys file is invalid, the current keys are kept.
+
+Returns:
+
+	an error if the keys file could not be read
+*/
+func (ring *keyring) reload() error {
+	keys, rates, err := readKeys(ring.keysFile)
+	if err != nil {
+		return err
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.keys, ring.rates = keys, rates
+	return nil
+}
+
+/*
+requestKey returns the API key of a request
+
+Args:
+
+	r: an HTTP request
+
+Returns:
+
+	the key given in the Authorization or X-API-Key header, or an empty string
+*/
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); len(key) > 0 {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+/*
+authenticate checks the API key of a request and counts the request
+
+Args:
+
+	r: an HTTP request
+
+Returns:
+
+	the name of the key's team
+
+	false when the key is missing or invalid
+*/
+func (ring *keyring) authenticate(r *http.Request) (string, bool) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	key, ok := ring.keys[requestKey(r)]
+	if !ok {
+		return "", false
+	}
+	usage := ring.usageOf(key.name)
+	usage.Requests++
+	usage.LastUsed = time.Now().UTC()
+	ring.dirty = true
+	return key.name, true
+}
+
+/*
+charge checks the rate limit of a team for a number of lookups; rejected requests are counted
+
+Args:
+
+	name: the team name, as returned by authenticate
+
+	count: the number of IP addresses to look up
+
+Returns:
+
+	0 when the lookups are allowed, otherwise the time to wait before retrying
+*/
+func (ring *keyring) charge(name string, count int) time.Duration {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	interval := ring.rates[name]
+	usage := ring.usageOf(name)
+	ring.dirty = true
+
+	// generic cell rate algorithm with a cost per lookup: allow up to apiKeyBurst lookups ahead of the rate;
+	// a request for more than apiKeyBurst lookups is allowed once the burst is available, and the team then
+	// waits until the rate catches up
+	now := time.Now()
+	next := ring.next[name]
+	if next.Before(now) {
+		next = now
+	}
+	if wait := next.Sub(now) - time.Duration(apiKeyBurst-min(count, apiKeyBurst))*interval; wait > 0 {
+		usage.Rejected++
+		return wait
+	}
+	ring.next[name] = next.Add(time.Duration(count) * interval)
+	return 0
+}
+
+/*
+allowLookups charges the lookups of a request to the rate limit of its team, replying 429 when it is exceeded
+
+Args:
+
+	w: the response
+
+	name: the team name, as returned by authenticate
+
+	count: the number of IP addresses to look up
+
+Returns:
+
+	false when the rate limit was exceeded and an error was replied
+*/
+func (ring *keyring) allowLookups(w http.ResponseWriter, name string, count int) bool {
+	wait := ring.charge(name, count)
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// usageOf returns the usage of a team, creating it when needed; the caller must hold ring.mu
+func (ring *keyring) usageOf(name string) *keyUsage {
+	usage, ok := ring.usage[name]
+	if !ok {
+		usage = &keyUsage{}
+		ring.usage[name] = usage
+	}
+	return usage
+}
+
+/*
+countLookups adds to the number of IP addresses looked up by a team
+
+Args:
+
+	name: the team name, as returned by authenticate
+
+	count: the number of IP addresses
+*/
+func (ring *keyring) countLookups(name string, count int) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.usageOf(name).Lookups += count
+	ring.dirty = true
+}
+
+/*
+report returns a copy of the usage of a team
+
+Args:
+
+	name: the team name
+
+Returns:
+
+	a keyUsage struct
+*/
+func (ring *keyring) report(name string) keyUsage {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	return *ring.usageOf(name)
+}
+
+/*
+save writes the usage of all teams to the usage database when it changed
+
+Returns:
+
+	an error if the usage could not be written
+*/
+func (ring *keyring) save() error {
+	ring.mu.Lock()
+	if !ring.dirty || ring.db == nil {
+		ring.mu.Unlock()
+		return nil
+	}
+	usage := make(map[string]keyUsage, len(ring.usage))
+	for name, u := range ring.usage {
+		usage[name] = *u
+	}
+	ring.dirty = false
+	ring.mu.Unlock()
+
+	// a single transaction, so that the usage of all teams is saved together
+	tx, err := ring.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, u := range usage {
+		_, err := tx.Exec(`INSERT INTO key_usage (name, requests, lookups, rejected, last_used) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET requests = excluded.requests, lookups = excluded.lookups,
+			rejected = excluded.rejected, last_used = excluded.last_used`, name, u.Requests, u.Lookups, u.Rejected, u.LastUsed)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+/*
+saveEvery calls save periodically; errors are output to stderr
+
+Args:
+
+	interval: the time between saves
+*/
+func (ring *keyring) saveEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := ring.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "usage error:", err)
+		}
+	}
+}
+
+/*
+requireKey wraps a handler so that it is only called for requests with a valid API key
+
+Args:
+
+	handler: the handler to wrap; it receives the team name of the key
+
+	lookups: the number of lookups each request is charged to the rate limit; 0 when the handler charges
+	the IP addresses it looks up with allowLookups
+
+Returns:
+
+	an http.HandlerFunc
+*/
+func (ring *keyring) requireKey(handler func(w http.ResponseWriter, r *http.Request, name string), lookups int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := ring.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if lookups > 0 && !ring.allowLookups(w, name, lookups) {
+			return
+		}
+		handler(w, r, name)
+	}
+}
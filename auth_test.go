@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeys writes a keys file and returns its name
+func writeKeys(t *testing.T, content string) string {
+	fname := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(fname, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestReadKeysConflictingRates(t *testing.T) {
+	fname := writeKeys(t, "key1 security 60/m\nkey2 netops 1000/h\nkey3 security 1/h\n")
+	_, _, err := readKeys(fname)
+	if err == nil || !strings.Contains(err.Error(), ":3: the rate of security differs from line 1") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestChargeTeamRate(t *testing.T) {
+	ring, err := loadKeyring(writeKeys(t, "key1 security 1/h\nkey2 netops 1000/h\nkey3 security 1/h\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both keys of security share its burst, whichever key is used
+	if wait := ring.charge("security", apiKeyBurst); wait != 0 {
+		t.Fatalf("first burst rejected: %v", wait)
+	}
+	if wait := ring.charge("security", 1); wait == 0 {
+		t.Error("lookup above the rate of security allowed")
+	}
+	if wait := ring.charge("netops", apiKeyBurst); wait != 0 {
+		t.Errorf("netops charged for the lookups of security: %v", wait)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+
+boltstore.go
+
+The bbolt storage backend of the cache, the history and the target sets, selected with a bolt:// DSN
+such as bolt:///var/lib/ipinfo/ipinfo.bolt, or bolt://ipinfo.bolt for a path relative to the current
+directory. The file is created when missing, with one bucket for each of them. Values are stored as JSON,
+the history under an increasing sequence number so that it is loaded in the order it was added.
+
+A bbolt file is locked by the process that opens it, so it can not be shared between ipinfo instances;
+within one process, the cache, the history and the target sets share a single handle of each file.
+
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltHistoryBucket    = []byte("history")
+	boltCacheBucket      = []byte("ip_cache")
+	boltTargetSetsBucket = []byte("target_sets")
+)
+
+// how long to wait for another process to release the lock of a bbolt file
+const boltLockTimeout = 5 * time.Second
+
+// the bbolt files opened by this process, with key=absolute file name
+var (
+	boltFiles     = make(map[string]*bolt.DB)
+	boltFilesLock sync.Mutex
+)
+
+/*
+connectBolt opens a bbolt file, creating it and its buckets when needed
+
+Args:
+
+	dsn: a URL such as bolt:///var/lib/ipinfo/ipinfo.bolt
+
+Returns:
+
+	the database, shared with the other users of the same file in this process
+*/
+func connectBolt(dsn string) (*bolt.DB, error) {
+	fname := dsn[len(storageScheme(dsn))+len("://"):]
+	if len(fname) == 0 {
+		return nil, fmt.Errorf("missing database file name: %s", dsn)
+	}
+	fname, err := filepath.Abs(fname)
+	if err != nil {
+		return nil, err
+	}
+	boltFilesLock.Lock()
+	defer boltFilesLock.Unlock()
+	if db, ok := boltFiles[fname]; ok {
+		return db, nil
+	}
+	db, err := bolt.Open(fname, 0600, &bolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltHistoryBucket, boltCacheBucket, boltTargetSetsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	boltFiles[fname] = db
+	return db, nil
+}
+
+// A history stored in the history bucket
+type boltHistory struct {
+	db *bolt.DB
+}
+
+func (h boltHistory) load() ([]historyRecord, error) {
+	var records []historyRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltHistoryBucket).ForEach(func(k, v []byte) error {
+			var record historyRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("history[%d]: %w", binary.BigEndian.Uint64(k), err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (h boltHistory) append(records []historyRecord) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHistoryBucket)
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			// big endian, so that the keys sort in the order the records were added
+			key := binary.BigEndian.AppendUint64(nil, id)
+			if err := bucket.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// A cache stored in the ip_cache bucket
+type boltCache struct {
+	db           *bolt.DB
+	ttl          time.Duration
+	forceRefresh bool
+}
+
+/*
+newBoltCache opens a bbolt file and removes the entries that are too old to be revalidated
+
+Args:
+
+	dsn: a URL such as bolt:///var/lib/ipinfo/ipinfo.bolt
+
+	ttl: how long entries are used before being revalidated
+
+	refresh: revalidate entries even when they have not expired
+
+Returns:
+
+	a boltCache
+*/
+func newBoltCache(dsn string, ttl time.Duration, refresh bool) (*boltCache, error) {
+	db, err := connectBolt(dsn)
+	if err != nil {
+		return nil, err
+	}
+	oldest := time.Now().Add(-cacheStaleRetention)
+	err = db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltCacheBucket).Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var entry cacheEntry
+			if json.Unmarshal(v, &entry) == nil && entry.Expires.After(oldest) {
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltCache{db: db, ttl: ttl, forceRefresh: refresh}, nil
+}
+
+func (c *boltCache) get(ip string) (cacheEntry, bool) {
+	var entry cacheEntry
+	var data []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		// the value is only valid during the transaction
+		data = append(data, tx.Bucket(boltCacheBucket).Get([]byte(ip))...)
+		return nil
+	})
+	if err := json.Unmarshal(data, &entry); err != nil || len(entry.Info.Ip) == 0 {
+		return entry, false
+	}
+	if time.Now().After(entry.Expires.Add(cacheStaleRetention)) {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *boltCache) set(ip string, entry cacheEntry) error {
+	entry.Expires = time.Now().Add(c.ttl)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCacheBucket).Put([]byte(ip), data)
+	})
+}
+
+func (c *boltCache) refresh() bool {
+	return c.forceRefresh
+}
+
+// Target sets stored in the target_sets bucket
+type boltTargetSets struct {
+	db *bolt.DB
+}
+
+func (s boltTargetSets) load() (map[string][]string, error) {
+	sets := make(map[string][]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTargetSetsBucket).ForEach(func(k, v []byte) error {
+			var targets []string
+			if err := json.Unmarshal(v, &targets); err != nil {
+				return fmt.Errorf("target_sets[%s]: %w", k, err)
+			}
+			sets[string(k)] = targets
+			return nil
+		})
+	})
+	return sets, err
+}
+
+func (s boltTargetSets) set(name string, targets []string) error {
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTargetSetsBucket).Put([]byte(name), data)
+	})
+}
+
+func (s boltTargetSets) remove(name string) (bool, error) {
+	var removed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTargetSetsBucket)
+		removed = bucket.Get([]byte(name)) != nil
+		return bucket.Delete([]byte(name))
+	})
+	return removed, err
+}
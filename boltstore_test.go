@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStore(t *testing.T) {
+	// the cache, the history and the target sets share the same file, as they can in one run
+	dsn := "bolt://" + filepath.Join(t.TempDir(), "ipinfo.bolt")
+	cache, err := openCache(dsn, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := openHistory(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := openTargetSets(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.set("192.0.2.1", cacheEntry{Info: ipInfoResult{Ip: "192.0.2.1", Country: "DE"}, ETag: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if entry, found := cache.get("192.0.2.1"); !found || entry.Info.Country != "DE" || entry.ETag != "abc" {
+		t.Errorf("got %v, %v", entry, found)
+	}
+	if _, found := cache.get("192.0.2.2"); found {
+		t.Error("found an entry that was never set")
+	}
+
+	for _, input := range []string{"a.example", "b.example", "c.example"} {
+		if err := history.append([]historyRecord{{resultRow: resultRow{Input: input}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := history.load()
+	if err != nil || len(records) != 3 || records[0].Input != "a.example" || records[2].Input != "c.example" {
+		t.Errorf("got %v, %v", records, err)
+	}
+
+	if err := sets.set("prod", []string{"a.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := sets.remove("test"); removed || err != nil {
+		t.Errorf("removed a missing target set: %v, %v", removed, err)
+	}
+	loaded, err := sets.load()
+	if err != nil || len(loaded) != 1 || loaded["prod"][0] != "a.example" {
+		t.Errorf("got %v, %v", loaded, err)
+	}
+}
@@ -0,0 +1,470 @@
+/*
+
+bot.go
+
+The bot subcommand connects to Slack with Socket Mode and/or to a Matrix homeserver, and answers
+chat messages such as "!ipinfo example.com 1.2.3.4" with one -oneline result per IP address.
+
+Slack needs an app-level token with the connections:write scope, and a bot token with the chat:write scope;
+the app must subscribe to the message.channels and message.im bot events. Matrix needs the access token of
+the bot's account; invitations to rooms are accepted automatically.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// how many Slack messages may wait for a free worker before new ones are dropped
+const slackQueueSize = 64
+
+// The state shared by all chat connections
+type bot struct {
+	workers     int
+	cache       ipCache
+	loc         string
+	prefix      string
+	maxTargets  int
+	concurrency int // the number of Slack messages answered at the same time
+	audit       *auditLog
+}
+
+// A Slack message waiting to be answered
+type slackMessage struct {
+	channel string
+	user    string
+	text    string
+}
+
+/*
+runBot implements the bot subcommand
+
+Args:
+
+	args: the command line arguments following "bot"
+
+	workers: the -t value given before the subcommand, used as the default
+
+	dsn: the -cache value given before the subcommand, used as the default
+
+	ttl: the -cache-ttl value given before the subcommand, used as the default
+
+Returns:
+
+	an error when no chat service is configured or a connection fails permanently
+*/
+func runBot(args []string, workers int, dsn string, ttl time.Duration) error {
+	flags := flag.NewFlagSet("bot", flag.ContinueOnError)
+	slackAppFlag := flags.String("slack-app-token", envString("SLACK_APP_TOKEN", ""), "Slack app-level token (xapp-...) used for Socket Mode; env: SLACK_APP_TOKEN")
+	slackBotFlag := flags.String("slack-bot-token", envString("SLACK_BOT_TOKEN", ""), "Slack bot token (xoxb-...) used to reply; env: SLACK_BOT_TOKEN")
+	matrixServerFlag := flags.String("matrix-homeserver", envString("MATRIX_HOMESERVER", ""), "Matrix homeserver URL, such as: https://matrix.example.org; env: MATRIX_HOMESERVER")
+	matrixTokenFlag := flags.String("matrix-token", envString("MATRIX_ACCESS_TOKEN", ""), "access token of the Matrix bot account; env: MATRIX_ACCESS_TOKEN")
+	prefixFlag := flags.String("prefix", envString("IPINFO_BOT_PREFIX", "!ipinfo"), "command that messages must start with; env: IPINFO_BOT_PREFIX")
+	maxTargetsFlag := flags.Int("max-targets", envInt("IPINFO_BOT_MAX_TARGETS", 10), "maximum number of targets per message; env: IPINFO_BOT_MAX_TARGETS")
+	workersFlag := flags.Int("t", envInt("IPINFO_THREADS", workers), "number of simultaneous threads per message; env: IPINFO_THREADS")
+	concurrencyFlag := flags.Int("concurrency", envInt("IPINFO_BOT_CONCURRENCY", 4), "number of Slack messages answered at the same time; env: IPINFO_BOT_CONCURRENCY")
+	cacheFlag := flags.String("cache", envString("IPINFO_CACHE", dsn), "share looked up IP info through this cache; env: IPINFO_CACHE")
+	cacheTTLFlag := flags.Duration("cache-ttl", envDuration("IPINFO_CACHE_TTL", ttl), "how long cache entries are used before being revalidated; env: IPINFO_CACHE_TTL")
+	openAudit := addAuditFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *concurrencyFlag < 1 {
+		return fmt.Errorf("-concurrency must be at least 1")
+	}
+	slack := len(*slackAppFlag) > 0 && len(*slackBotFlag) > 0
+	matrix := len(*matrixServerFlag) > 0 && len(*matrixTokenFlag) > 0
+	if !slack && !matrix {
+		return fmt.Errorf("bot needs -slack-app-token and -slack-bot-token, or -matrix-homeserver and -matrix-token")
+	}
+	cache, err := openCache(*cacheFlag, *cacheTTLFlag, false)
+	if err != nil {
+		return err
+	}
+	audit, err := openAudit()
+	if err != nil {
+		return err
+	}
+	local := callRemoteService("")
+	if isRateLimit(local.ErrMsg) {
+		return local.ErrMsg
+	}
+	b := &bot{workers: *workersFlag, cache: cache, loc: local.Loc, prefix: *prefixFlag, maxTargets: *maxTargetsFlag, concurrency: *concurrencyFlag, audit: audit}
+
+	errCh := make(chan error, 2)
+	if slack {
+		go func() { errCh <- b.runSlack(*slackAppFlag, *slackBotFlag) }()
+	}
+	if matrix {
+		go func() { errCh <- b.runMatrix(strings.TrimSuffix(*matrixServerFlag, "/"), *matrixTokenFlag) }()
+	}
+	return <-errCh
+}
+
+/*
+answer looks up the targets of a chat command
+
+Args:
+
+	text: the text of a chat message
+
+	who: the chat service and user that sent the message, for the audit log
+
+Returns:
+
+	the reply, and false when the message is not a command; failed lookups are reported in the reply
+*/
+func (b *bot) answer(text string, who string) (string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || fields[0] != b.prefix {
+		return "", false
+	}
+	targets := fields[1:]
+	if len(targets) == 0 {
+		return "usage: " + b.prefix + " <host name, IP address, URL or email address...>", true
+	}
+	if len(targets) > b.maxTargets {
+		return fmt.Sprintf("at most %d targets can be looked up at once", b.maxTargets), true
+	}
+
+	hostnames := normalizedTargets(targets)
+	ipAddrs, _, _, hostAddrs := runDNSHosts(b.workers, hostnames, false)
+	cached := b.audit.cachedBefore(b.cache, ipAddrs)
+	ipInfo, lookupErr := resolveAllIpInfoFunc(b.workers, ipAddrs, b.cache, nil)
+	infos := make(map[string]ipInfoResult, len(ipInfo))
+	for _, info := range ipInfo {
+		infos[info.Ip] = info
+	}
+	b.audit.record("bot", who, "", targets, ipAddrs, cached)
+	var lines []string
+	for _, hostname := range hostnames {
+		found := false
+		// an address shared by several hostnames is reported for each of them
+		for _, ip := range hostAddrs[hostname] {
+			info, ok := infos[ip]
+			if !ok {
+				continue
+			}
+			row := buildRow(info, map[string]string{ip: hostname}, b.loc, extraColumns{})
+			if row.ErrMsg != nil {
+				lines = append(lines, row.Ip+": lookup failed: "+row.ErrMsg.Error())
+			} else {
+				lines = append(lines, formatOneline(row, nil))
+			}
+			found = true
+		}
+		if !found && lookupErr != nil {
+			lines = append(lines, hostname+": lookup failed: ipinfo.io refused further requests, try again later")
+		} else if !found {
+			lines = append(lines, hostname+": lookup failed")
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+/*
+requestJSON sends a JSON request with a bearer token and decodes the JSON response
+
+Args:
+
+	method: the HTTP method
+
+	endpoint: the URL
+
+	token: the bearer token
+
+	body: the request body, or nil
+
+	result: where the response is decoded to, or nil
+
+Returns:
+
+	an error if the request failed or the response status is not 2xx
+*/
+func requestJSON(method string, endpoint string, token string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Slack wraps links in angle brackets, such as <http://example.com|example.com>
+var slackLink = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]+))?>`)
+
+/*
+slackText converts Slack message markup back into plain text
+
+Args:
+
+	text: the text of a Slack message
+
+Returns:
+
+	the text with links replaced by their label, or their URL when they have no label
+*/
+func slackText(text string) string {
+	text = slackLink.ReplaceAllStringFunc(text, func(link string) string {
+		parts := slackLink.FindStringSubmatch(link)
+		if len(parts[2]) > 0 {
+			return parts[2]
+		}
+		return strings.TrimPrefix(parts[1], "mailto:")
+	})
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+}
+
+/*
+runSlack answers Slack messages received with Socket Mode, reconnecting whenever Slack asks to; the messages
+are answered by b.concurrency workers
+
+Args:
+
+	appToken: the app-level token
+
+	botToken: the bot token
+
+Returns:
+
+	an error when a connection can not be opened
+*/
+func (b *bot) runSlack(appToken string, botToken string) error {
+	queue := make(chan slackMessage, slackQueueSize)
+	for i := 0; i < b.concurrency; i++ {
+		go b.slackWorker(queue, botToken)
+	}
+	for {
+		var opened struct {
+			Ok    bool   `json:"ok"`
+			Error string `json:"error"`
+			URL   string `json:"url"`
+		}
+		if err := requestJSON(http.MethodPost, "https://slack.com/api/apps.connections.open", appToken, nil, &opened); err != nil {
+			return err
+		}
+		if !opened.Ok {
+			return fmt.Errorf("slack: %s", opened.Error)
+		}
+		ws, err := websocket.Dial(opened.URL, "", "https://slack.com")
+		if err != nil {
+			return err
+		}
+		if err := b.slackSession(ws, queue); err != nil {
+			fmt.Fprintln(os.Stderr, "slack:", err)
+			time.Sleep(5 * time.Second)
+		}
+		ws.Close()
+	}
+}
+
+/*
+slackSession handles the envelopes of a single Socket Mode connection
+
+Args:
+
+	ws: the Socket Mode connection
+
+	queue: the messages to answer; when it is full, messages are dropped so that envelopes are still acknowledged in time
+
+Returns:
+
+	nil when Slack asks to reconnect, otherwise the connection error
+*/
+func (b *bot) slackSession(ws *websocket.Conn, queue chan<- slackMessage) error {
+	for {
+		var envelope struct {
+			EnvelopeID string `json:"envelope_id"`
+			Type       string `json:"type"`
+			Payload    struct {
+				Event struct {
+					Type    string `json:"type"`
+					Subtype string `json:"subtype"`
+					BotID   string `json:"bot_id"`
+					Channel string `json:"channel"`
+					User    string `json:"user"`
+					Text    string `json:"text"`
+				} `json:"event"`
+			} `json:"payload"`
+		}
+		if err := websocket.JSON.Receive(ws, &envelope); err != nil {
+			return err
+		}
+		if envelope.Type == "disconnect" {
+			return nil
+		}
+		if len(envelope.EnvelopeID) > 0 {
+			if err := websocket.JSON.Send(ws, map[string]string{"envelope_id": envelope.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+		event := envelope.Payload.Event
+		if envelope.Type != "events_api" || event.Type != "message" || len(event.Subtype) > 0 || len(event.BotID) > 0 {
+			continue
+		}
+		select {
+		case queue <- slackMessage{channel: event.Channel, user: event.User, text: event.Text}:
+		default:
+			fmt.Fprintln(os.Stderr, "slack: too many messages waiting, dropped a message from", event.User)
+		}
+	}
+}
+
+/*
+slackWorker answers the Slack messages of a queue, one at a time
+
+Args:
+
+	queue: the messages to answer
+
+	botToken: the bot token
+*/
+func (b *bot) slackWorker(queue <-chan slackMessage, botToken string) {
+	for msg := range queue {
+		reply, ok := b.answer(slackText(msg.text), "slack:"+msg.user)
+		if !ok {
+			continue
+		}
+		var posted struct {
+			Ok    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		message := map[string]string{"channel": msg.channel, "text": "```" + reply + "```"}
+		err := requestJSON(http.MethodPost, "https://slack.com/api/chat.postMessage", botToken, message, &posted)
+		if err == nil && !posted.Ok {
+			err = fmt.Errorf("chat.postMessage: %s", posted.Error)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "slack:", err)
+		}
+	}
+}
+
+/*
+runMatrix answers Matrix messages received with long polling /sync requests; messages sent before the bot started are ignored
+
+Args:
+
+	homeserver: the homeserver URL, without a trailing slash
+
+	token: the access token of the bot account
+
+Returns:
+
+	an error when the homeserver rejects the access token
+*/
+func (b *bot) runMatrix(homeserver string, token string) error {
+	api := homeserver + "/_matrix/client/v3"
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := requestJSON(http.MethodGet, api+"/account/whoami", token, nil, &whoami); err != nil {
+		return err
+	}
+
+	since := ""
+	txn := time.Now().UnixNano()
+	for {
+		var sync struct {
+			NextBatch string `json:"next_batch"`
+			Rooms     struct {
+				Join map[string]struct {
+					Timeline struct {
+						Events []struct {
+							Type    string `json:"type"`
+							Sender  string `json:"sender"`
+							Content struct {
+								MsgType string `json:"msgtype"`
+								Body    string `json:"body"`
+							} `json:"content"`
+						} `json:"events"`
+					} `json:"timeline"`
+				} `json:"join"`
+				Invite map[string]json.RawMessage `json:"invite"`
+			} `json:"rooms"`
+		}
+		query := url.Values{"timeout": {"30000"}}
+		if len(since) > 0 {
+			query.Set("since", since)
+		}
+		if err := requestJSON(http.MethodGet, api+"/sync?"+query.Encode(), token, nil, &sync); err != nil {
+			if strings.Contains(err.Error(), "401") {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "matrix:", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		initial := len(since) == 0
+		since = sync.NextBatch
+
+		for roomID := range sync.Rooms.Invite {
+			if err := requestJSON(http.MethodPost, api+"/join/"+url.PathEscape(roomID), token, map[string]string{}, nil); err != nil {
+				fmt.Fprintln(os.Stderr, "matrix:", err)
+			}
+		}
+		if initial {
+			continue
+		}
+		for roomID, room := range sync.Rooms.Join {
+			for _, event := range room.Timeline.Events {
+				if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Sender == whoami.UserID {
+					continue
+				}
+				reply, ok := b.answer(event.Content.Body, "matrix:"+event.Sender)
+				if !ok {
+					continue
+				}
+				txn++
+				endpoint := api + "/rooms/" + url.PathEscape(roomID) + "/send/m.room.message/" + strconv.FormatInt(txn, 10)
+				message := map[string]string{"msgtype": "m.notice", "body": reply}
+				if err := requestJSON(http.MethodPut, endpoint, token, message, nil); err != nil {
+					fmt.Fprintln(os.Stderr, "matrix:", err)
+				}
+			}
+		}
+	}
+}
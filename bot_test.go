@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnswerWorkersReturn(t *testing.T) {
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{})
+	b := &bot{workers: 30, prefix: "!ip", maxTargets: 10}
+	checkGoroutines(t, func() {
+		for i := 0; i < 5; i++ {
+			reply, ok := b.answer("!ip a.example b.example", "test")
+			if !ok || strings.Count(reply, "Berlin") != 2 {
+				t.Fatalf("unexpected reply: %q", reply)
+			}
+		}
+	})
+}
+
+func TestAnswerRateLimited(t *testing.T) {
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{limited: true})
+	b := &bot{workers: 4, prefix: "!ip", maxTargets: 10}
+	reply, ok := b.answer("!ip a.example", "test")
+	if !ok || !strings.Contains(reply, "try again later") {
+		t.Errorf("unexpected reply: %q", reply)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+
+braces.go
+
+Shell style brace expansion of targets, so that a numbered series of hosts can be given at once:
+
+	web{01..20}.example.com       web01.example.com ... web20.example.com
+	db{1..9..2}.example.com       db1, db3, db5, db7 and db9
+	{www,api,cdn}.example.com     www.example.com, api.example.com and cdn.example.com
+	10.0.{0..3}.1                 10.0.0.1 ... 10.0.3.1
+
+Braces that contain neither a range nor a comma are left as they are.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the largest number of targets a single argument may expand to
+const maxBraceExpansion = 65536
+
+var braceRange = regexp.MustCompile(`^(-?\d+)\.\.(-?\d+)(?:\.\.(-?\d+))?$`)
+var braceLetters = regexp.MustCompile(`^(?:([a-z])\.\.([a-z])|([A-Z])\.\.([A-Z]))$`)
+
+/*
+braceAlternatives returns the alternatives of the inside of a brace pair
+
+Args:
+
+	inner: the text between the braces, such as 01..20 or www,api
+
+Returns:
+
+	the alternatives, or nil when inner is not a range or list; an error when a range is too large
+*/
+func braceAlternatives(inner string) ([]string, error) {
+	if m := braceRange.FindStringSubmatch(inner); m != nil {
+		first, _ := strconv.Atoi(m[1])
+		last, _ := strconv.Atoi(m[2])
+		step := 1
+		if len(m[3]) > 0 {
+			step, _ = strconv.Atoi(m[3])
+		}
+		if step < 0 {
+			step = -step
+		}
+		if step == 0 {
+			return nil, nil
+		}
+		if count := (last-first)/step + 1; count > maxBraceExpansion || -count > maxBraceExpansion {
+			return nil, fmt.Errorf("{%s} expands to more than %d targets", inner, maxBraceExpansion)
+		}
+		// a leading zero on either end pads all numbers to the same width, as in bash
+		width := 0
+		for _, end := range m[1:3] {
+			if digits := strings.TrimPrefix(end, "-"); len(digits) > 1 && digits[0] == '0' && len(end) > width {
+				width = len(end)
+			}
+		}
+		var alternatives []string
+		for n := first; (first <= last && n <= last) || (first > last && n >= last); {
+			alternatives = append(alternatives, fmt.Sprintf("%0*d", width, n))
+			if first <= last {
+				n += step
+			} else {
+				n -= step
+			}
+		}
+		return alternatives, nil
+	}
+	if m := braceLetters.FindStringSubmatch(inner); m != nil {
+		var alternatives []string
+		first, last := m[1]+m[3], m[2]+m[4]
+		for c := int(first[0]); ; {
+			alternatives = append(alternatives, string(rune(c)))
+			if c == int(last[0]) {
+				break
+			}
+			if first[0] < last[0] {
+				c++
+			} else {
+				c--
+			}
+		}
+		return alternatives, nil
+	}
+
+	// a comma separated list; commas inside nested braces do not split it
+	var alternatives []string
+	depth, start := 0, 0
+	for i, c := range inner {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			alternatives = append(alternatives, inner[start:i])
+			start = i + 1
+		}
+	}
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+	return append(alternatives, inner[start:]), nil
+}
+
+/*
+expandBraces expands all brace pairs of a target
+
+Args:
+
+	arg: a target, such as web{01..20}.example.com
+
+Returns:
+
+	all expanded targets, in order; arg itself when it has nothing to expand
+*/
+func expandBraces(arg string) ([]string, error) {
+	for open := strings.Index(arg, "{"); open >= 0; {
+		depth, end := 0, -1
+		for i := open; i < len(arg) && end < 0; i++ {
+			switch arg[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		alternatives, err := braceAlternatives(arg[open+1 : end])
+		if err != nil {
+			return nil, err
+		}
+		if alternatives != nil {
+			var expanded []string
+			for _, alternative := range alternatives {
+				more, err := expandBraces(arg[:open] + alternative + arg[end+1:])
+				if err != nil {
+					return nil, err
+				}
+				expanded = append(expanded, more...)
+				if len(expanded) > maxBraceExpansion {
+					return nil, fmt.Errorf("%s expands to more than %d targets", arg, maxBraceExpansion)
+				}
+			}
+			return expanded, nil
+		}
+		next := strings.Index(arg[open+1:], "{")
+		if next < 0 {
+			break
+		}
+		open += next + 1
+	}
+	return []string{arg}, nil
+}
+
+/*
+expandAllBraces calls expandBraces for each target
+
+Args:
+
+	args: the targets
+
+Returns:
+
+	the expanded targets, in order
+*/
+func expandAllBraces(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		more, err := expandBraces(arg)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, more...)
+	}
+	return expanded, nil
+}
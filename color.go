@@ -0,0 +1,86 @@
+/*
+
+color.go
+
+Support for -color, which highlights rows of the table with ANSI colors:
+failed lookups in red, IP addresses listed in -feeds in magenta, distances above -color-distance in yellow
+and your own IP address in cyan.
+
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The rows to highlight; a nil colorScheme disables colors
+type colorScheme struct {
+	distance float64
+	localIp  string
+}
+
+/*
+useColor decides if the table is output with colors
+
+Args:
+
+	mode: auto, always or never
+
+	w: where the table is written to; with auto, colors are only used for a terminal when NO_COLOR is not set
+
+Returns:
+
+	true when colors should be used
+*/
+func useColor(mode string, w io.Writer) bool {
+	switch mode {
+	case "always":
+		return true
+	case "auto":
+		file, ok := w.(*os.File)
+		if !ok || len(os.Getenv("NO_COLOR")) > 0 || os.Getenv("TERM") == "dumb" {
+			return false
+		}
+		info, err := file.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	return false
+}
+
+/*
+rowColors returns the colors of a table row
+
+Args:
+
+	row: the resultRow struct of the table row
+
+	width: the number of cells in the table row
+
+Returns:
+
+	the color of each cell, or nil when the row is not highlighted
+*/
+func (scheme *colorScheme) rowColors(row resultRow, width int) []tablewriter.Colors {
+	var color tablewriter.Colors
+	switch {
+	case !lookupSucceeded(row):
+		color = tablewriter.Colors{tablewriter.FgRedColor}
+	case len(row.Feeds) > 0:
+		color = tablewriter.Colors{tablewriter.Bold, tablewriter.FgMagentaColor}
+	case row.Ip == scheme.localIp:
+		color = tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor}
+	case row.Distance != nil && scheme.distance > 0 && *row.Distance > scheme.distance:
+		color = tablewriter.Colors{tablewriter.FgYellowColor}
+	default:
+		return nil
+	}
+	colors := make([]tablewriter.Colors, width)
+	for i := range colors {
+		colors[i] = color
+	}
+	return colors
+}
@@ -0,0 +1,402 @@
+/*
+
+compare.go
+
+Support for -compare, which looks up each IP address with several geolocation providers
+and outputs their answers side by side, so that the quality of each source can be judged.
+
+Providers:
+
+	ipinfo: ipinfo.io, the same data as the main table
+	ip-api: ip-api.com, limited to 45 requests per minute without a key
+	mmdb:   MaxMind DB files, such as GeoLite2-City.mmdb and GeoLite2-ASN.mmdb, given with -mmdb
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// A source of IP geolocation data for -compare
+type geoProvider struct {
+	name   string
+	lookup func(ip string) (ipInfoResult, error)
+}
+
+// The answers of all providers for a single IP address; key=provider name
+type providerAnswers map[string]ipInfoResult
+
+/*
+parseProviders converts the value of -compare into a list of providers
+
+Args:
+
+	spec: a comma separated list of provider names, optionally prefixed with "providers=", such as: providers=ipinfo,ip-api,mmdb
+
+	mmdbFiles: a comma separated list of MaxMind DB files used by the mmdb provider
+
+	cache: the cache used by the ipinfo provider
+
+Returns:
+
+	a slice of geoProvider, in the same order as given in spec
+*/
+func parseProviders(spec string, mmdbFiles string, cache ipCache) ([]geoProvider, error) {
+	var providers []geoProvider
+	for _, name := range strings.Split(strings.TrimPrefix(spec, "providers="), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case "ipinfo":
+			providers = append(providers, geoProvider{name, func(ip string) (ipInfoResult, error) {
+				info := lookupIpInfo(ip, cache)
+				exitOnRateLimit(info.ErrMsg)
+				if !hasLocation(info.Loc) {
+					return info, fmt.Errorf("no location")
+				}
+				return info, nil
+			}})
+		case "ip-api":
+			providers = append(providers, geoProvider{name, lookupIpApi})
+		case "mmdb":
+			lookup, err := openMaxMindDBs(mmdbFiles)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, geoProvider{name, lookup})
+		default:
+			return nil, fmt.Errorf("unknown provider: %s", name)
+		}
+	}
+	if len(providers) < 2 {
+		return nil, fmt.Errorf("-compare needs at least two providers, such as: providers=ipinfo,ip-api")
+	}
+	return providers, nil
+}
+
+/*
+lookupIpApi retrieves the location of an IP address from ip-api.com
+
+Args:
+
+	ip: an IP address
+
+Returns:
+
+	an ipInfoResult struct; the Org field contains the AS number and name
+*/
+func lookupIpApi(ip string) (ipInfoResult, error) {
+	info := ipInfoResult{Ip: ip}
+	resp, err := http.Get("http://ip-api.com/json/" + ip + "?fields=status,message,countryCode,regionName,city,lat,lon,as")
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	body, err := readResponse(resp)
+	if err != nil {
+		return info, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("ip-api.com: %s", resp.Status)
+	}
+
+	var answer struct {
+		Status      string  `json:"status"`
+		Message     string  `json:"message"`
+		CountryCode string  `json:"countryCode"`
+		RegionName  string  `json:"regionName"`
+		City        string  `json:"city"`
+		Lat         float64 `json:"lat"`
+		Lon         float64 `json:"lon"`
+		AS          string  `json:"as"`
+	}
+	if err := decodeJSON(body, &answer); err != nil {
+		return info, fmt.Errorf("ip-api.com: %w", err)
+	}
+	if answer.Status != "success" {
+		return info, fmt.Errorf("ip-api.com: %s", sanitizeText(answer.Message))
+	}
+	info.Country = answer.CountryCode
+	info.Region = answer.RegionName
+	info.City = answer.City
+	info.Loc = fmt.Sprintf("%.4f,%.4f", answer.Lat, answer.Lon)
+	info.Org = answer.AS
+	info.sanitize()
+	return info, nil
+}
+
+// The fields read from City, Country and ASN MaxMind databases
+type mmdbRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude       *float64 `maxminddb:"latitude"`
+		Longitude      *float64 `maxminddb:"longitude"`
+		AccuracyRadius uint     `maxminddb:"accuracy_radius"`
+	} `maxminddb:"location"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+/*
+openMaxMindDBs opens the MaxMind DB files used by the mmdb provider
+
+Args:
+
+	fileList: a comma separated list of .mmdb files; the answers of all files are merged,
+	so a City and an ASN database can be combined
+
+Returns:
+
+	a lookup function for a geoProvider
+*/
+func openMaxMindDBs(fileList string) (func(ip string) (ipInfoResult, error), error) {
+	var readers []*maxminddb.Reader
+	for _, fname := range strings.Split(fileList, ",") {
+		fname = strings.TrimSpace(fname)
+		if len(fname) == 0 {
+			continue
+		}
+		reader, err := maxminddb.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+	if len(readers) == 0 {
+		return nil, fmt.Errorf("the mmdb provider needs a database given with -mmdb")
+	}
+
+	return func(ip string) (ipInfoResult, error) {
+		info := ipInfoResult{Ip: ip}
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return info, fmt.Errorf("invalid IP address: %s", ip)
+		}
+		found := false
+		for _, reader := range readers {
+			var record mmdbRecord
+			if err := reader.Lookup(addr, &record); err != nil {
+				return info, err
+			}
+			if name := record.City.Names["en"]; len(name) > 0 {
+				info.City, found = name, true
+			}
+			if len(record.Subdivisions) > 0 {
+				info.Region = record.Subdivisions[0].Names["en"]
+			}
+			if len(record.Country.IsoCode) > 0 {
+				info.Country, found = record.Country.IsoCode, true
+			}
+			if record.Location.Latitude != nil && record.Location.Longitude != nil {
+				info.Loc = fmt.Sprintf("%.4f,%.4f", *record.Location.Latitude, *record.Location.Longitude)
+				info.Radius = int(record.Location.AccuracyRadius)
+			}
+			if record.ASN > 0 {
+				info.Org, found = strings.TrimSpace(fmt.Sprintf("AS%d %s", record.ASN, record.ASOrg)), true
+			}
+		}
+		if !found {
+			return info, fmt.Errorf("not found")
+		}
+		info.sanitize()
+		return info, nil
+	}, nil
+}
+
+/*
+lookupAllProviders concurrently looks up each IP address with each provider
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	providers: a slice as returned by parseProviders
+
+	rows: the rows of the main table; their IP info is reused for the ipinfo provider
+
+Returns:
+
+	a map where key=IP address, value=the answers of all providers; failed lookups are missing
+	and their errors are found in the second map, where key=IP address + " " + provider name
+*/
+func lookupAllProviders(workers int, providers []geoProvider, rows []resultRow) (map[string]providerAnswers, map[string]error) {
+	answers := make(map[string]providerAnswers)
+	failures := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, workers)
+	for _, row := range rows {
+		if _, ok := answers[row.Ip]; ok {
+			continue
+		}
+		answers[row.Ip] = make(providerAnswers)
+		if hasLocation(row.Loc) {
+			answers[row.Ip]["ipinfo"] = row.ipInfoResult
+		}
+	}
+	for ip := range answers {
+		for _, provider := range providers {
+			mu.Lock()
+			_, known := answers[ip][provider.name]
+			mu.Unlock()
+			if known {
+				continue
+			}
+			wg.Add(1)
+			limit <- true
+			go func(ip string, provider geoProvider) {
+				defer wg.Done()
+				info, err := provider.lookup(ip)
+				mu.Lock()
+				if err != nil {
+					failures[ip+" "+provider.name] = err
+				} else {
+					answers[ip][provider.name] = info
+				}
+				mu.Unlock()
+				<-limit
+			}(ip, provider)
+		}
+	}
+	wg.Wait()
+	return answers, failures
+}
+
+/*
+asNumber returns the AS number found at the start of an org field, such as "AS16509" for "AS16509 Amazon.com, Inc."
+
+Args:
+
+	org: the Org field of an ipInfoResult
+
+Returns:
+
+	the AS number, or an empty string when org does not start with one
+*/
+func asNumber(org string) string {
+	fields := strings.Fields(org)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "AS") {
+		return ""
+	}
+	return fields[0]
+}
+
+/*
+disagreements returns the fields where providers gave different answers and the largest distance between their locations
+
+Args:
+
+	answers: the answers of all providers for a single IP address
+
+Returns:
+
+	a slice containing any of: country, city, asn
+
+	the largest distance in miles between any two locations
+*/
+func disagreements(answers providerAnswers) ([]string, float64) {
+	fields := []struct {
+		name  string
+		value func(ipInfoResult) string
+	}{
+		{"country", func(info ipInfoResult) string { return strings.ToUpper(info.Country) }},
+		{"city", func(info ipInfoResult) string { return strings.ToLower(info.City) }},
+		{"asn", func(info ipInfoResult) string { return asNumber(info.Org) }},
+	}
+	var differ []string
+	for _, field := range fields {
+		var distinct []string
+		for _, info := range answers {
+			if value := field.value(info); len(value) > 0 && !stringInSlice(value, distinct) {
+				distinct = append(distinct, value)
+			}
+		}
+		if len(distinct) > 1 {
+			differ = append(differ, field.name)
+		}
+	}
+
+	var locations []string
+	for _, info := range answers {
+		if hasLocation(info.Loc) {
+			locations = append(locations, info.Loc)
+		}
+	}
+	spread := 0.0
+	for i := range locations {
+		for j := i + 1; j < len(locations); j++ {
+			lat1, lon1 := latlon2coord(locations[i])
+			lat2, lon2 := latlon2coord(locations[j])
+			if distance := HaversineDistance(lat1, lon1, lat2, lon2); distance > spread {
+				spread = distance
+			}
+		}
+	}
+	return differ, spread
+}
+
+/*
+outputComparison outputs a table with the answer of each provider side by side
+
+Args:
+
+	w: where to write the table to
+
+	rows: the rows of the main table
+
+	providers: a slice as returned by parseProviders
+
+	answers: a map as returned by lookupAllProviders
+
+	failures: a map as returned by lookupAllProviders
+
+	wrap: wrap output to better fit the screen width
+*/
+func outputComparison(w io.Writer, rows []resultRow, providers []geoProvider, answers map[string]providerAnswers, failures map[string]error, wrap bool) {
+	header := []string{"Input", "IP"}
+	for _, provider := range providers {
+		header = append(header, provider.name)
+	}
+	header = append(header, "Disagree", "Spread (mi)")
+
+	var allRows [][]string
+	for _, row := range rows {
+		cells := []string{row.Input, row.Ip}
+		for _, provider := range providers {
+			info, ok := answers[row.Ip][provider.name]
+			if !ok {
+				cells = append(cells, fmt.Sprintf("error: %v", failures[row.Ip+" "+provider.name]))
+				continue
+			}
+			cells = append(cells, fmt.Sprintf("%s / %s / %s", orNA(info.Country), orNA(info.City), orNA(asNumber(info.Org))))
+		}
+		differ, spread := disagreements(answers[row.Ip])
+		cells = append(cells, strings.Join(differ, ","), strconv.FormatFloat(spread, 'f', 2, 64))
+		allRows = append(allRows, cells)
+	}
+
+	table := newTable(w)
+	table.SetHeader(header)
+	table.SetAutoWrapText(wrap)
+	table.AppendBulk(allRows)
+	table.Render()
+}
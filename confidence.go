@@ -0,0 +1,125 @@
+/*
+
+confidence.go
+
+Support for -confidence, which scores how much each location can be trusted, from 0 to 100.
+The score starts at 100 and is lowered by each warning sign:
+
+	no location                       the score is 0
+	placeholder coordinates           -40, such as 0,0 or the default location of a country
+	anycast address                   -40, the address is announced from many locations
+	no city                           -20, the location is only known at country level
+	impossible RTT                    -40, with -geo-verify
+	providers disagree                -10 to -30, with -compare, depending on the distance between their locations
+	large accuracy radius             -5 to -20, with -compare and the mmdb provider
+
+*/
+
+package main
+
+// Coordinates that geolocation databases use when only the country is known, in "lat,lon" format
+var placeholderLocations = []string{
+	"0.0000,0.0000",
+	"37.7510,-97.8220",  // United States
+	"38.0000,-97.0000",  // United States
+	"37.0902,-95.7129",  // United States
+	"60.0000,-95.0000",  // Canada
+	"51.4964,-0.1224",   // United Kingdom
+	"51.0000,9.0000",    // Germany
+	"47.0000,8.0000",    // Switzerland
+	"35.0000,105.0000",  // China
+	"36.0000,138.0000",  // Japan
+	"-27.0000,133.0000", // Australia
+}
+
+/*
+isPlaceholderLocation determines if a location is a well known placeholder instead of a real location
+
+Args:
+
+	loc: a location in "lat,lon" format
+
+Returns:
+
+	true when loc is within about a mile of a placeholder
+*/
+func isPlaceholderLocation(loc string) bool {
+	lat1, lon1 := latlon2coord(loc)
+	for _, placeholder := range placeholderLocations {
+		lat2, lon2 := latlon2coord(placeholder)
+		if HaversineDistance(lat1, lon1, lat2, lon2) < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+confidenceScore scores how much the location of a row can be trusted
+
+Args:
+
+	row: the row, before its location is truncated
+
+	loc: the local IP addresses location in this format: "lat, lon"
+
+	columns: the RTTs of -geo-verify and the answers of -compare are used when given
+
+Returns:
+
+	a score from 0, no confidence, to 100
+*/
+func confidenceScore(row resultRow, loc string, columns extraColumns) int {
+	if !hasLocation(row.Loc) {
+		return 0
+	}
+	score := 100
+	if isPlaceholderLocation(row.Loc) {
+		score -= 40
+	}
+	if row.Anycast != nil && *row.Anycast {
+		score -= 40
+	}
+	if len(row.City) == 0 {
+		score -= 20
+	}
+
+	if result, ok := columns.rtts[row.Ip]; ok && result.err == nil && hasLocation(loc) {
+		lat1, lon1 := latlon2coord(loc)
+		lat2, lon2 := latlon2coord(row.Loc)
+		if result.rtt < minimumRTT(HaversineDistance(lat1, lon1, lat2, lon2)) {
+			score -= 40
+		}
+	}
+
+	if answers, ok := columns.answers[row.Ip]; ok {
+		_, spread := disagreements(answers)
+		switch {
+		case spread > 500:
+			score -= 30
+		case spread > 100:
+			score -= 20
+		case spread > 25:
+			score -= 10
+		}
+		radius := 0
+		for _, info := range answers {
+			if info.Radius > radius {
+				radius = info.Radius
+			}
+		}
+		switch {
+		case radius >= 500:
+			score -= 20
+		case radius >= 100:
+			score -= 10
+		case radius >= 50:
+			score -= 5
+		}
+	}
+
+	if score < 0 {
+		return 0
+	}
+	return score
+}
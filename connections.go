@@ -0,0 +1,240 @@
+/*
+
+connections.go
+
+Support for -connections, which geolocates the remote peers of this machine's established TCP
+connections and connected UDP sockets, for a quick look at who it is talking to. On Linux the
+connections are read from /proc/net; on other systems from the output of netstat -an.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An established connection to a remote peer
+type connection struct {
+	proto  string
+	remote string
+	port   int
+}
+
+/*
+parseProcAddress converts an address of /proc/net/tcp, such as 0100007F:0050, into an IP address and port
+
+Args:
+
+	field: the hex encoded address and port; the address is stored as 32 bit words in host byte order
+
+Returns:
+
+	the IP address and port, or an error
+*/
+func parseProcAddress(field string) (string, int, error) {
+	hexIP, hexPort, found := strings.Cut(field, ":")
+	raw, err := hex.DecodeString(hexIP)
+	if !found || err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return "", 0, fmt.Errorf("invalid address: %s", field)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port: %s", field)
+	}
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip.String(), int(port), nil
+}
+
+/*
+procConnections reads the established connections from /proc/net
+
+Returns:
+
+	a slice of connection structs
+*/
+func procConnections() ([]connection, error) {
+	var connections []connection
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		file, err := os.Open("/proc/net/" + proto)
+		if os.IsNotExist(err) { // IPv6 may be disabled
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// 01 is TCP_ESTABLISHED, which is also used for connected UDP sockets
+			if len(fields) < 4 || fields[3] != "01" {
+				continue
+			}
+			ip, port, err := parseProcAddress(fields[2])
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			connections = append(connections, connection{proto: strings.TrimSuffix(proto, "6"), remote: ip, port: port})
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return connections, nil
+}
+
+/*
+splitNetstatAddress splits an address of netstat -an into an IP address and port
+
+Args:
+
+	address: such as 1.2.3.4:443 or [::1]:443 on Windows, and 1.2.3.4.443 or fe80::1%en0.443 on macOS and BSD
+
+Returns:
+
+	the IP address and port, and false when address has no valid IP address and port
+*/
+func splitNetstatAddress(address string) (string, int, bool) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		dot := strings.LastIndex(address, ".")
+		if dot < 0 {
+			return "", 0, false
+		}
+		host, portText = address[:dot], address[dot+1:]
+	}
+	host, _, _ = strings.Cut(host, "%")
+	port, err := strconv.Atoi(portText)
+	if net.ParseIP(host) == nil || err != nil {
+		return "", 0, false
+	}
+	return host, port, true
+}
+
+/*
+netstatConnections reads the established connections from the output of netstat -an
+
+Returns:
+
+	a slice of connection structs
+*/
+func netstatConnections() ([]connection, error) {
+	out, err := exec.Command("netstat", "-an").Output()
+	if err != nil {
+		return nil, fmt.Errorf("netstat: %w", err)
+	}
+	// Windows: Proto Local Foreign State; macOS and BSD: Proto Recv-Q Send-Q Local Foreign (state)
+	remoteColumn := 4
+	if runtime.GOOS == "windows" {
+		remoteColumn = 2
+	}
+	var connections []connection
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) <= remoteColumn {
+			continue
+		}
+		proto := strings.ToLower(fields[0])
+		switch {
+		case strings.HasPrefix(proto, "tcp"):
+			if fields[len(fields)-1] != "ESTABLISHED" {
+				continue
+			}
+			proto = "tcp"
+		case strings.HasPrefix(proto, "udp"):
+			proto = "udp"
+		default:
+			continue
+		}
+		ip, port, ok := splitNetstatAddress(fields[remoteColumn])
+		if !ok || port == 0 || net.ParseIP(ip).IsUnspecified() {
+			continue
+		}
+		connections = append(connections, connection{proto: proto, remote: ip, port: port})
+	}
+	return connections, nil
+}
+
+/*
+runConnections geolocates the public remote peers of all established connections
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an error if the connections could not be read
+*/
+func runConnections(workers int, cache ipCache) error {
+	var connections []connection
+	var err error
+	if runtime.GOOS == "linux" {
+		connections, err = procConnections()
+	} else {
+		connections, err = netstatConnections()
+	}
+	if err != nil {
+		return err
+	}
+
+	// the number of connections and the remote ports of each peer
+	counts := make(map[string]int)
+	ports := make(map[string][]string)
+	var ipAddrs []string
+	for _, conn := range connections {
+		if !isPublicIP(conn.remote) {
+			continue
+		}
+		if counts[conn.remote] == 0 {
+			ipAddrs = append(ipAddrs, conn.remote)
+		}
+		counts[conn.remote]++
+		port := conn.proto + "/" + strconv.Itoa(conn.port)
+		if !stringInSlice(port, ports[conn.remote]) {
+			ports[conn.remote] = append(ports[conn.remote], port)
+		}
+	}
+	if len(ipAddrs) == 0 {
+		return fmt.Errorf("no established connections to public IP addresses found")
+	}
+
+	infos := make(map[string]ipInfoResult)
+	for _, info := range resolveAllIpInfo(workers, ipAddrs, cache) {
+		infos[info.Ip] = info
+	}
+	sort.SliceStable(ipAddrs, func(a, b int) bool {
+		return counts[ipAddrs[a]] > counts[ipAddrs[b]]
+	})
+	var allRows [][]string
+	for _, ip := range ipAddrs {
+		info := infos[ip]
+		allRows = append(allRows, []string{ip, strings.Join(ports[ip], ", "), strconv.Itoa(counts[ip]), orNA(info.Hostname), orNA(info.Org), orNA(info.City), orNA(info.Region), orNA(info.Country)})
+	}
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"Remote IP", "Ports", "Connections", "Hostname", "Org", "City", "Region", "Country"})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
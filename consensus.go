@@ -0,0 +1,140 @@
+/*
+
+consensus.go
+
+Support for -consensus, which combines the answers of the -compare providers into a single location.
+When all locations are within the threshold distance of each other, their centroid is used;
+otherwise the location given by a majority of providers is used and the row is flagged as low confidence.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The combined location of all provider answers for a single IP address
+type consensusResult struct {
+	city       string
+	country    string
+	loc        string
+	agree      int
+	total      int
+	confidence string
+}
+
+/*
+centroid returns the average of the given locations; this is accurate enough for nearby locations
+
+Args:
+
+	answers: a slice of ipInfoResult, all with a location
+
+Returns:
+
+	a location in "lat,lon" format
+*/
+func centroid(answers []ipInfoResult) string {
+	var sumLat, sumLon float64
+	for _, info := range answers {
+		lat, lon := latlon2coord(info.Loc)
+		sumLat += lat
+		sumLon += lon
+	}
+	count := float64(len(answers))
+	return fmt.Sprintf("%.4f,%.4f", sumLat/count, sumLon/count)
+}
+
+/*
+findConsensus combines the answers of all providers for a single IP address
+
+Args:
+
+	answers: the answers of all providers for a single IP address
+
+	threshold: the distance in miles that providers may diverge by and still be considered in agreement
+
+Returns:
+
+	a consensusResult struct; confidence is "high", "low" or "none" when no majority was found
+*/
+func findConsensus(answers providerAnswers, threshold float64) consensusResult {
+	// the providers are visited in name order, so that ties between groups are broken the same way on every run
+	var names []string
+	for name := range answers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var located []ipInfoResult
+	for _, name := range names {
+		if info := answers[name]; hasLocation(info.Loc) {
+			located = append(located, info)
+		}
+	}
+	result := consensusResult{total: len(located), confidence: "none"}
+	if len(located) == 0 {
+		return result
+	}
+
+	// group the answers by city, the largest group is the majority
+	groups := make(map[string][]ipInfoResult)
+	var majority []ipInfoResult
+	for _, info := range located {
+		key := strings.ToUpper(info.Country) + "/" + strings.ToLower(info.City)
+		groups[key] = append(groups[key], info)
+		if len(groups[key]) > len(majority) {
+			majority = groups[key]
+		}
+	}
+
+	_, spread := disagreements(answers)
+	if spread <= threshold {
+		result.loc = centroid(located)
+		result.agree = len(located)
+		result.confidence = "high"
+	} else if 2*len(majority) > len(located) {
+		result.loc = centroid(majority)
+		result.agree = len(majority)
+		result.confidence = "low"
+	} else {
+		return result
+	}
+	result.city = majority[0].City
+	result.country = majority[0].Country
+	return result
+}
+
+/*
+outputConsensus outputs a table with the consensus location of each IP address
+
+Args:
+
+	w: where to write the table to
+
+	rows: the rows of the main table
+
+	answers: a map as returned by lookupAllProviders
+
+	threshold: the distance in miles that providers may diverge by and still be considered in agreement
+
+	wrap: wrap output to better fit the screen width
+*/
+func outputConsensus(w io.Writer, rows []resultRow, answers map[string]providerAnswers, threshold float64, wrap bool) {
+	var allRows [][]string
+	for _, row := range rows {
+		result := findConsensus(answers[row.Ip], threshold)
+		agree := strconv.Itoa(result.agree) + "/" + strconv.Itoa(result.total)
+		allRows = append(allRows, []string{row.Input, row.Ip, orNA(result.city), orNA(result.country), orNA(result.loc), agree, result.confidence})
+	}
+
+	table := newTable(w)
+	table.SetHeader([]string{"Input", "IP", "City", "Country", "Loc", "Agree", "Confidence"})
+	table.SetAutoWrapText(wrap)
+	table.AppendBulk(allRows)
+	table.Render()
+}
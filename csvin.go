@@ -0,0 +1,158 @@
+/*
+
+csvin.go
+
+Support for -csv-in and -csv-column, which read the targets from a column of an existing CSV file,
+and -csv-enrich, which outputs that CSV file again with the result columns appended to each record.
+
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A CSV file that targets were read from
+type csvInput struct {
+	records   [][]string
+	column    int  // zero based
+	hasHeader bool // the first record is a header
+}
+
+/*
+readCSVInput reads a CSV file and finds its target column
+
+Args:
+
+	fname: the file name, or - for standard input
+
+	column: a one based column number, or a column name from the header; the first record is a header when
+	a name is given, or when its cell in the column contains no "." or ":", which every target contains
+
+Returns:
+
+	a pointer to a csvInput struct
+*/
+func readCSVInput(fname string, column string) (*csvInput, error) {
+	var r io.Reader = os.Stdin
+	if fname != "-" {
+		file, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no records found", fname)
+	}
+
+	input := &csvInput{records: records}
+	if number, err := strconv.Atoi(column); err == nil {
+		if number < 1 {
+			return nil, fmt.Errorf("invalid -csv-column: %s: columns are numbered from 1", column)
+		}
+		input.column = number - 1
+		first := ""
+		if input.column < len(records[0]) {
+			first = records[0][input.column]
+		}
+		input.hasHeader = !strings.ContainsAny(first, ".:")
+		return input, nil
+	}
+	for i, name := range records[0] {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			input.column, input.hasHeader = i, true
+			return input, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no column named %s", fname, column)
+}
+
+// data returns the records without the header
+func (input *csvInput) data() [][]string {
+	if input.hasHeader {
+		return input.records[1:]
+	}
+	return input.records
+}
+
+/*
+targets returns the non-empty values of the target column
+
+Returns:
+
+	a slice of targets, in file order
+*/
+func (input *csvInput) targets() []string {
+	var targets []string
+	for _, record := range input.data() {
+		if input.column < len(record) {
+			if target := strings.TrimSpace(record[input.column]); len(target) > 0 {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+/*
+outputEnrichedCSV outputs the CSV file with the result columns appended to each record;
+when a target resolved to several IP addresses, the first result is used
+
+Args:
+
+	w: where to write the output to
+
+	input: the CSV file the targets were read from
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	columns: the data for optional columns; only columns with data are appended
+
+Returns:
+
+	an error if the output could not be written
+*/
+func outputEnrichedCSV(w io.Writer, input *csvInput, rows []resultRow, columns extraColumns) error {
+	header, allCells := tableCells(rows, columns)
+	results := make(map[string][]string)
+	for i, row := range rows {
+		if _, ok := results[row.Input]; !ok {
+			results[row.Input] = allCells[i][1:] // the input is already in the record
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	if input.hasHeader {
+		if err := writer.Write(append(append([]string{}, input.records[0]...), header[1:]...)); err != nil {
+			return err
+		}
+	}
+	empty := make([]string, len(header)-1)
+	for _, record := range input.data() {
+		appended := empty
+		if input.column < len(record) {
+			if cells, ok := results[normalizeTarget(truncateArgParts([]string{strings.TrimSpace(record[input.column])})[0])]; ok {
+				appended = cells
+			}
+		}
+		if err := writer.Write(append(append([]string{}, record...), appended...)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,40 @@
+-- the run of ipinfo that looked up a set of targets
+CREATE TABLE runs (
+    id          bigserial PRIMARY KEY,
+    started_at  timestamptz NOT NULL,
+    source_ip   inet,
+    source_loc  text,
+    targets     integer NOT NULL
+);
+
+-- the latest IP info of each IP address ever looked up
+CREATE TABLE ips (
+    ip          inet PRIMARY KEY,
+    hostname    text,
+    org         text,
+    asn         text,
+    city        text,
+    region      text,
+    country     text,
+    postal      text,
+    latitude    double precision,
+    longitude   double precision,
+    first_seen  timestamptz NOT NULL,
+    last_seen   timestamptz NOT NULL
+);
+
+-- each input and IP address looked up by a run
+CREATE TABLE lookups (
+    id          bigserial PRIMARY KEY,
+    run_id      bigint NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+    ip          inet NOT NULL REFERENCES ips (ip),
+    input       text NOT NULL,
+    country     text,
+    org         text,
+    distance    double precision
+);
+
+CREATE INDEX lookups_run_id ON lookups (run_id);
+CREATE INDEX lookups_ip ON lookups (ip);
+CREATE INDEX lookups_input ON lookups (input);
+CREATE INDEX runs_started_at ON runs (started_at);
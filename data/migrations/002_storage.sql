@@ -0,0 +1,20 @@
+-- the records of -history, as JSON in the order they were added
+CREATE TABLE history (
+    id          bigserial PRIMARY KEY,
+    record      jsonb NOT NULL
+);
+
+-- the entries of -cache, as JSON with the validators returned by ipinfo.io
+CREATE TABLE ip_cache (
+    ip          text PRIMARY KEY,
+    entry       jsonb NOT NULL,
+    expires_at  timestamptz NOT NULL
+);
+
+-- the target sets of the targets subcommand, with their targets as a JSON array
+CREATE TABLE target_sets (
+    name        text PRIMARY KEY,
+    targets     jsonb NOT NULL
+);
+
+CREATE INDEX ip_cache_expires_at ON ip_cache (expires_at);
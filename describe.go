@@ -0,0 +1,146 @@
+/*
+
+describe.go
+
+Support for -describe-output, which outputs a JSON description of every output field so that
+other tools can build column pickers without hardcoding the field list.
+
+*/
+
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+)
+
+// The description of a single output field, as output by -describe-output
+type outputField struct {
+	Name        string   `json:"name"`
+	Field       string   `json:"template_field"`
+	Type        string   `json:"type"`
+	Nullable    bool     `json:"nullable"`
+	Description string   `json:"description"`
+	Providers   []string `json:"providers"`
+	Flag        string   `json:"flag,omitempty"`
+}
+
+// Details of each JSON field of resultRow that can not be derived from the struct itself
+var outputFieldDetails = map[string]struct {
+	description string
+	providers   []string
+	flag        string
+}{
+	"input":        {"the command line argument or file entry that was looked up", []string{"input"}, ""},
+	"ip":           {"an IP address the input resolved to", []string{"dns"}, ""},
+	"hostname":     {"the reverse DNS name of the IP address", []string{"ipinfo.io"}, ""},
+	"city":         {"the city the IP address is located in", []string{"ipinfo.io"}, ""},
+	"region":       {"the region or state the IP address is located in", []string{"ipinfo.io"}, ""},
+	"country":      {"the two letter country code the IP address is located in", []string{"ipinfo.io"}, ""},
+	"loc":          {"the latitude and longitude of the IP address", []string{"ipinfo.io"}, ""},
+	"postal":       {"the postal code of the IP address", []string{"ipinfo.io"}, ""},
+	"org":          {"the AS number and organization that announces the IP address", []string{"ipinfo.io"}, ""},
+	"anycast":      {"true when the IP address is anycast, announced from many locations", []string{"ipinfo.io"}, ""},
+	"asn":          {"the AS number, name, domain and type (isp, hosting, business...) of the IP address; only given by paid ipinfo.io plans", []string{"ipinfo.io"}, ""},
+	"distance":     {"the distance in miles from your own location", []string{"ipinfo.io"}, ""},
+	"ttl":          {"the remaining DNS TTL of the address in seconds", []string{"dns"}, "-ttl"},
+	"spf":          {"the \"all\" mechanism of the domain's SPF record", []string{"dns"}, "-mail-policy"},
+	"dmarc":        {"the policy of the domain's DMARC record", []string{"dns"}, "-mail-policy"},
+	"mx":           {"the number of MX records of the domain", []string{"dns"}, "-mail-policy"},
+	"cert_org":     {"the organization in the subject of the TLS certificate", []string{"tls"}, "-tls"},
+	"cert_country": {"the country in the subject of the TLS certificate", []string{"tls"}, "-tls"},
+	"rtt_ms":       {"the lowest TCP connect time in milliseconds", []string{"rtt"}, "-geo-verify"},
+	"nearest_city": {"the nearest major city to the location", []string{"dataset"}, "-nearest"},
+	"nearest_ixp":  {"the nearest internet exchange (IXP) to the location", []string{"dataset"}, "-nearest"},
+	"tags":         {"the country groups the country belongs to", []string{"dataset"}, "-tags"},
+	"confidence":   {"a score from 0 to 100 of how much the location can be trusted", []string{"ipinfo.io", "rtt", "compare"}, "-confidence"},
+	"feeds":        {"the threat feeds that list the IP address or a network containing it", []string{"feeds"}, "-feeds"},
+	"mx_priority":  {"the priority of the MX record of the mail server; lower values are preferred", []string{"dns"}, "-mx"},
+	"records":      {"the DNS records of the input requested with -records, by record type", []string{"dns"}, "-records"},
+}
+
+/*
+jsonType returns the JSON type that values of t are encoded as
+
+Args:
+
+	t: a Go type
+
+Returns:
+
+	a JSON type name, and whether the value may be null
+*/
+func jsonType(t reflect.Type) (string, bool) {
+	if t.Kind() == reflect.Ptr {
+		name, _ := jsonType(t.Elem())
+		return name, true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", false
+	case reflect.Int, reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return "integer", false
+	case reflect.Float32, reflect.Float64:
+		return "number", false
+	case reflect.Bool:
+		return "boolean", false
+	case reflect.Slice:
+		name, _ := jsonType(t.Elem())
+		return "array of " + name, true
+	}
+	return "object", false
+}
+
+/*
+describeFields returns a description of the fields of t that are included in JSON output
+
+Args:
+
+	t: a struct type; embedded structs are described in place
+
+Returns:
+
+	a slice of outputField, in output order
+*/
+func describeFields(t reflect.Type) []outputField {
+	var fields []outputField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			fields = append(fields, describeFields(field.Type)...)
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || len(name) == 0 {
+			continue
+		}
+		details := outputFieldDetails[name]
+		kind, nullable := jsonType(field.Type)
+		fields = append(fields, outputField{
+			Name:        name,
+			Field:       field.Name,
+			Type:        kind,
+			Nullable:    nullable,
+			Description: details.description,
+			Providers:   details.providers,
+			Flag:        details.flag,
+		})
+	}
+	return fields
+}
+
+/*
+outputDescription outputs the description of all result fields as a JSON array
+
+Args:
+
+	w: where to write the output to
+
+Returns:
+
+	an error if the output could not be written
+*/
+func outputDescription(w io.Writer) error {
+	return writeJSON(w, describeFields(reflect.TypeOf(resultRow{})))
+}
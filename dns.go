@@ -0,0 +1,571 @@
+/*
+
+dns.go
+
+DNS queries performed with github.com/miekg/dns instead of the system resolver.
+This allows per-record details, such as the remaining TTL, to be displayed.
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+const resolvConf string = "/etc/resolv.conf"
+
+// A resolver that DNS queries are sent to instead of the name servers of /etc/resolv.conf
+type encryptedResolver interface {
+	exchange(msg *dns.Msg) (*dns.Msg, error)
+}
+
+// the resolver given with -doh or -dot, or nil to use the system resolver
+var dnsResolver encryptedResolver
+
+// the address family that host names are resolved to: ip for both, ip4 with -4 or ip6 with -6
+var ipFamily = "ip"
+
+/*
+inIPFamily reports whether an IP address belongs to the address family selected with -4 or -6
+
+Args:
+
+	ip: an IP address
+
+Returns:
+
+	true when the address is output
+*/
+func inIPFamily(ip string) bool {
+	addr := net.ParseIP(ip)
+	switch ipFamily {
+	case "ip4":
+		return addr != nil && addr.To4() != nil
+	case "ip6":
+		return addr != nil && addr.To4() == nil
+	}
+	return true
+}
+
+// the name servers that queryDNS sends questions to when no -doh or -dot server is given
+var nameServers = dnsServers
+
+/*
+dnsServers returns the list of name servers found in /etc/resolv.conf
+
+Returns:
+
+	a slice of "host:port" entries, or an error if the resolver configuration could not be read
+*/
+func dnsServers() ([]string, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("reading the resolver configuration is not supported on %s", runtime.GOOS)
+	}
+	config, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, server := range config.Servers {
+		servers = append(servers, net.JoinHostPort(server, config.Port))
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no name servers found in %s", resolvConf)
+	}
+	return servers, nil
+}
+
+// The error of a DNS query that the name server answered with an rcode other than NOERROR
+type rcodeError struct {
+	name  string
+	rcode int
+}
+
+func (e *rcodeError) Error() string {
+	return fmt.Sprintf("lookup %s: %s", e.name, dns.RcodeToString[e.rcode])
+}
+
+/*
+isNXDomain reports if a DNS query failed because the name does not exist
+
+Args:
+
+	err: an error returned by queryDNS
+
+Returns:
+
+	true when the name server answered NXDOMAIN
+*/
+func isNXDomain(err error) bool {
+	var rcodeErr *rcodeError
+	return errors.As(err, &rcodeErr) && rcodeErr.rcode == dns.RcodeNameError
+}
+
+/*
+queryDNS sends a single question to the first name server that answers, or to the -doh or -dot server
+
+Args:
+
+	name: the host name to look up
+
+	qtype: the record type, such as dns.TypeA
+
+Returns:
+
+	all resource records in the answer section; an *rcodeError when the answer is not NOERROR
+*/
+func queryDNS(name string, qtype uint16) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(asciiHost(name)), qtype)
+	msg.RecursionDesired = true
+	// large answers, such as the TXT records of big domains, do not fit into the 512 bytes of plain DNS over UDP
+	msg.SetEdns0(4096, false)
+
+	if dnsResolver != nil {
+		reply, err := dnsResolver.exchange(msg)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", name, err)
+		}
+		if reply.Rcode != dns.RcodeSuccess {
+			return nil, &rcodeError{name: name, rcode: reply.Rcode}
+		}
+		return reply.Answer, nil
+	}
+
+	servers, err := nameServers()
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		reply, err := exchangeDNS(msg, server)
+		if err != nil {
+			continue
+		}
+		if reply.Rcode != dns.RcodeSuccess {
+			return nil, &rcodeError{name: name, rcode: reply.Rcode}
+		}
+		return reply.Answer, nil
+	}
+	return nil, fmt.Errorf("lookup %s: no name server responded", name)
+}
+
+/*
+exchangeDNS sends a question to a name server over UDP, and again over TCP when the answer was truncated
+
+Args:
+
+	msg: the question
+
+	server: the name server, as "host:port"
+
+Returns:
+
+	the complete reply
+*/
+func exchangeDNS(msg *dns.Msg, server string) (*dns.Msg, error) {
+	reply, _, err := new(dns.Client).Exchange(msg, server)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Truncated {
+		// miekg/dns returns truncated replies without an error, which would silently drop records
+		reply, _, err = (&dns.Client{Net: "tcp"}).Exchange(msg, server)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+/*
+asciiHost converts an internationalized domain name, such as münchen.de, to the punycode form used by DNS
+
+Args:
+
+	hostname: a host name or IP address
+
+Returns:
+
+	the punycode form, such as xn--mnchen-3ya.de; ASCII and invalid names are returned unchanged
+*/
+func asciiHost(hostname string) string {
+	for _, r := range hostname {
+		if r >= utf8.RuneSelf {
+			if ascii, err := idna.Lookup.ToASCII(hostname); err == nil {
+				return ascii
+			}
+			return hostname
+		}
+	}
+	return hostname
+}
+
+/*
+unicodeHost converts the punycode labels of a host name to Unicode for display; see asciiHost
+
+Args:
+
+	hostname: a host name or IP address
+
+Returns:
+
+	the Unicode form, such as münchen.de for xn--mnchen-3ya.de; other names are returned unchanged
+*/
+func unicodeHost(hostname string) string {
+	if !strings.Contains(strings.ToLower(hostname), "xn--") {
+		return hostname
+	}
+	if unicode, err := idna.Display.ToUnicode(hostname); err == nil {
+		return unicode
+	}
+	return hostname
+}
+
+/*
+lookupHost is net.LookupHost for host names that may be internationalized; with -4 or -6,
+only the A or AAAA records are resolved, and with -doh or -dot, they are resolved by that server
+
+Args:
+
+	hostname: a host name or IP address
+
+Returns:
+
+	a slice of IP addresses
+*/
+func lookupHost(hostname string) ([]string, error) {
+	if dnsResolver != nil {
+		addresses, _, err := lookupHostTTL(hostname)
+		return addresses, err
+	}
+	if ipFamily == "ip" || net.ParseIP(hostname) != nil {
+		return net.LookupHost(asciiHost(hostname))
+	}
+	ips, err := net.DefaultResolver.LookupIP(context.Background(), ipFamily, asciiHost(hostname))
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = ip.String()
+	}
+	return addresses, nil
+}
+
+/*
+lookupHostTTL is similar to net.LookupHost, but also returns the remaining TTL for each address
+
+Args:
+
+	hostname: a host name or IP address
+
+Returns:
+
+	a slice of IP addresses
+
+	a map with key=ip, value=TTL in seconds; IP address input has no TTL entry
+*/
+func lookupHostTTL(hostname string) ([]string, map[string]uint32, error) {
+	ttls := make(map[string]uint32)
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}, ttls, nil
+	}
+
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	switch ipFamily {
+	case "ip4":
+		qtypes = qtypes[:1]
+	case "ip6":
+		qtypes = qtypes[1:]
+	}
+	var addresses []string
+	for _, qtype := range qtypes {
+		answers, err := queryDNS(hostname, qtype)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rr := range answers {
+			var ip string
+			switch record := rr.(type) {
+			case *dns.A:
+				ip = record.A.String()
+			case *dns.AAAA:
+				ip = record.AAAA.String()
+			default: // skip CNAME records that lead to the address
+				continue
+			}
+			addresses = append(addresses, ip)
+			ttls[ip] = rr.Header().Ttl
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, nil, fmt.Errorf("lookup %s: no such host", hostname)
+	}
+	return addresses, ttls, nil
+}
+
+/*
+parseRecordTypes converts a comma separated list such as "A,AAAA,MX" into DNS record types
+
+Args:
+
+	list: the value given to the -records command line option
+
+Returns:
+
+	a slice of DNS record types, in the same order as given in list
+*/
+func parseRecordTypes(list string) ([]uint16, error) {
+	var qtypes []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		qtype, ok := dns.StringToType[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown DNS record type: %s", name)
+		}
+		qtypes = append(qtypes, qtype)
+	}
+	return qtypes, nil
+}
+
+// A DNS record requested with -records, or the error of its query
+type dnsRecord struct {
+	TTL   *uint32 `json:"ttl,omitempty"`
+	Data  string  `json:"data,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+/*
+lookupRecords concurrently fetches the given record types for each hostname; IP addresses are skipped
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	hostnames: a slice containing the hostnames to look up
+
+	qtypes: the DNS record types to query for each hostname
+
+Returns:
+
+	a map with key=hostname, value=a map with key=record type, such as MX, value=the records of that type
+*/
+func lookupRecords(workers int, hostnames []string, qtypes []uint16) map[string]map[string][]dnsRecord {
+	records := make(map[string]map[string][]dnsRecord)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, workers)
+	for _, hostname := range hostnames {
+		if net.ParseIP(hostname) != nil {
+			continue
+		}
+		records[hostname] = make(map[string][]dnsRecord)
+		for _, qtype := range qtypes {
+			wg.Add(1)
+			limit <- true
+			go func(hostname string, qtype uint16) {
+				defer wg.Done()
+				var found []dnsRecord
+				answers, err := queryDNS(hostname, qtype)
+				if err != nil {
+					found = append(found, dnsRecord{Error: err.Error()})
+				}
+				for _, rr := range answers {
+					if rr.Header().Rrtype != qtype { // skip CNAME records that lead to the answer
+						continue
+					}
+					ttl := rr.Header().Ttl
+					found = append(found, dnsRecord{TTL: &ttl, Data: strings.TrimPrefix(rr.String(), rr.Header().String())})
+				}
+				mu.Lock()
+				records[hostname][dns.TypeToString[qtype]] = found
+				mu.Unlock()
+				<-limit
+			}(hostname, qtype)
+		}
+	}
+	wg.Wait()
+	return records
+}
+
+/*
+formatRecords formats the records of a host name for a single table cell
+
+Args:
+
+	records: the records of a host name, as returned by lookupRecords
+
+	qtypes: the requested record types, in the order they are output
+
+Returns:
+
+	such as "MX 300 10 mx.example.com.; TXT 300 v=spf1 -all", or "N/A" when there are none
+*/
+func formatRecords(records map[string][]dnsRecord, qtypes []uint16) string {
+	var parts []string
+	for _, qtype := range qtypes {
+		name := dns.TypeToString[qtype]
+		for _, record := range records[name] {
+			if len(record.Error) > 0 {
+				parts = append(parts, name+" "+record.Error)
+			} else {
+				parts = append(parts, name+" "+strconv.FormatUint(uint64(*record.TTL), 10)+" "+record.Data)
+			}
+		}
+	}
+	return orNA(strings.Join(parts, "; "))
+}
+
+// A summary of how a domain handles email, as shown by the -mail-policy columns
+type mailPolicy struct {
+	spf   string
+	dmarc string
+	mx    string
+}
+
+/*
+txtRecords returns the TXT records for name, with multiple strings of a single record joined together
+
+Args:
+
+	name: the DNS name to look up
+
+Returns:
+
+	a slice containing one entry per TXT record
+*/
+func txtRecords(name string) ([]string, error) {
+	answers, err := queryDNS(name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, rr := range answers {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+	return records, nil
+}
+
+/*
+lookupMailPolicy summarizes the SPF, DMARC and MX records of a domain
+
+Args:
+
+	domain: the domain name to examine
+
+Returns:
+
+	a mailPolicy struct; SPF is summarized by its "all" mechanism, DMARC by its policy and MX by the number of records
+*/
+func lookupMailPolicy(domain string) mailPolicy {
+	policy := mailPolicy{spf: "none", dmarc: "none", mx: "none"}
+
+	if records, err := txtRecords(domain); err != nil {
+		policy.spf = "error"
+	} else {
+		for _, txt := range records {
+			if !strings.HasPrefix(strings.ToLower(txt), "v=spf1") {
+				continue
+			}
+			policy.spf = "no all"
+			for _, mechanism := range strings.Fields(txt) {
+				// the all mechanism may have a qualifier, such as -all or ~all
+				name := strings.ToLower(mechanism)
+				if strings.ContainsRune("+-~?", rune(name[0])) {
+					name = name[1:]
+				}
+				if name == "all" {
+					policy.spf = mechanism
+				}
+			}
+		}
+	}
+
+	if records, err := txtRecords("_dmarc." + domain); err != nil {
+		if !isNXDomain(err) {
+			policy.dmarc = "error"
+		}
+	} else {
+		for _, txt := range records {
+			if !strings.HasPrefix(strings.ToLower(txt), "v=dmarc1") {
+				continue
+			}
+			policy.dmarc = "no policy"
+			for _, tag := range strings.Split(txt, ";") {
+				tag = strings.TrimSpace(tag)
+				if strings.HasPrefix(strings.ToLower(tag), "p=") {
+					policy.dmarc = tag
+				}
+			}
+		}
+	}
+
+	if answers, err := queryDNS(domain, dns.TypeMX); err != nil {
+		policy.mx = "error"
+	} else {
+		count := 0
+		for _, rr := range answers {
+			if _, ok := rr.(*dns.MX); ok {
+				count++
+			}
+		}
+		if count > 0 {
+			policy.mx = strconv.Itoa(count)
+		}
+	}
+	return policy
+}
+
+/*
+lookupMailPolicies concurrently calls lookupMailPolicy for each hostname; IP addresses are skipped
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	hostnames: a slice containing the hostnames to look up
+
+Returns:
+
+	a map with key=hostname, value=mailPolicy
+*/
+func lookupMailPolicies(workers int, hostnames []string) map[string]mailPolicy {
+	policies := make(map[string]mailPolicy)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, workers)
+	for _, hostname := range hostnames {
+		if net.ParseIP(hostname) != nil {
+			continue
+		}
+		wg.Add(1)
+		limit <- true
+		go func(hostname string) {
+			defer wg.Done()
+			policy := lookupMailPolicy(hostname)
+			mu.Lock()
+			policies[hostname] = policy
+			mu.Unlock()
+			<-limit
+		}(hostname)
+	}
+	wg.Wait()
+	return policies
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// The TXT records of a large domain; the SPF record comes last, so it is lost when the answer is truncated
+var largeTXT = []string{
+	"google-site-verification=" + strings.Repeat("a", 43),
+	"docusign=" + strings.Repeat("b", 36),
+	"MS=ms" + strings.Repeat("1", 8),
+	"apple-domain-verification=" + strings.Repeat("c", 16),
+	"globalsign-smime-dv=" + strings.Repeat("d", 44),
+	"facebook-domain-verification=" + strings.Repeat("e", 30),
+	"v=spf1 include:_spf.example.com ~all",
+}
+
+/*
+startTruncatingServer starts a name server on 127.0.0.1 that answers UDP questions with a truncated reply
+holding only the first answer, and TCP questions with the complete reply
+
+Args:
+
+	answers: returns the complete answer to a question
+
+Returns:
+
+	the "host:port" of the server, the same for UDP and TCP
+*/
+func startTruncatingServer(t *testing.T, answers func(q dns.Question) []dns.RR) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		t.Skip("no UDP port matching the TCP port:", err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(r)
+		reply.Answer = answers(r.Question[0])
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && len(reply.Answer) > 1 {
+			reply.Answer = reply.Answer[:1]
+			reply.Truncated = true
+		}
+		w.WriteMsg(reply)
+	})
+	udpServer := &dns.Server{PacketConn: conn, Handler: handler}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	t.Cleanup(func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	})
+	return listener.Addr().String()
+}
+
+// useNameServer sends the queries of queryDNS to server until the test ends
+func useNameServer(t *testing.T, server string) {
+	previousResolver, previousServers := dnsResolver, nameServers
+	dnsResolver = nil
+	nameServers = func() ([]string, error) { return []string{server}, nil }
+	t.Cleanup(func() {
+		dnsResolver, nameServers = previousResolver, previousServers
+	})
+}
+
+// txtAnswers answers TXT questions for example.com with largeTXT
+func txtAnswers(q dns.Question) []dns.RR {
+	if q.Qtype != dns.TypeTXT || q.Name != "example.com." {
+		return nil
+	}
+	var answers []dns.RR
+	for _, txt := range largeTXT {
+		answers = append(answers, &dns.TXT{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300}, Txt: []string{txt}})
+	}
+	return answers
+}
+
+func TestQueryDNSTruncated(t *testing.T) {
+	useNameServer(t, startTruncatingServer(t, txtAnswers))
+	answers, err := queryDNS("example.com", dns.TypeTXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != len(largeTXT) {
+		t.Errorf("got %d answers, want all %d", len(answers), len(largeTXT))
+	}
+}
+
+func TestMailPolicyTruncated(t *testing.T) {
+	useNameServer(t, startTruncatingServer(t, func(q dns.Question) []dns.RR {
+		if q.Name == "_dmarc.example.com." && q.Qtype == dns.TypeTXT {
+			return []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300}, Txt: []string{"v=DMARC1; p=reject"}}}
+		}
+		return txtAnswers(q)
+	}))
+	policy := lookupMailPolicy("example.com")
+	if policy.spf != "~all" || policy.dmarc != "p=reject" {
+		t.Errorf("got spf %q and dmarc %q, want ~all and p=reject", policy.spf, policy.dmarc)
+	}
+}
+
+func TestLookupRecordsTruncated(t *testing.T) {
+	useNameServer(t, startTruncatingServer(t, func(q dns.Question) []dns.RR {
+		if q.Qtype != dns.TypeMX {
+			return txtAnswers(q)
+		}
+		var answers []dns.RR
+		for i, host := range []string{"mx1", "mx2", "mx3"} {
+			answers = append(answers, &dns.MX{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 300}, Preference: uint16(10 * (i + 1)), Mx: host + ".example.com."})
+		}
+		return answers
+	}))
+	records := lookupRecords(2, []string{"example.com"}, []uint16{dns.TypeTXT, dns.TypeMX})["example.com"]
+	if len(records["TXT"]) != len(largeTXT) || len(records["MX"]) != 3 {
+		t.Errorf("got %d TXT and %d MX records, want %d and 3", len(records["TXT"]), len(records["MX"]), len(largeTXT))
+	}
+}
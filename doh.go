@@ -0,0 +1,89 @@
+/*
+
+doh.go
+
+Support for -doh, which resolves host names with DNS over HTTPS (RFC 8484) instead of the system resolver,
+such as: -doh https://cloudflare-dns.com/dns-query. On networks whose resolvers filter or rewrite answers,
+this gives the answers of a trusted resolver. See encryptedResolver in dns.go.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A DNS over HTTPS endpoint
+type dohResolver struct {
+	url    string
+	client *http.Client // connections are reused across queries
+}
+
+/*
+newDoHResolver validates the value given to -doh
+
+Args:
+
+	server: the URL of a DNS over HTTPS endpoint
+
+Returns:
+
+	a pointer to a dohResolver struct, or an error when server is not an https URL
+*/
+func newDoHResolver(server string) (*dohResolver, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid -doh server: %s; expected such as https://cloudflare-dns.com/dns-query", server)
+	}
+	return &dohResolver{url: server, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+/*
+exchange sends a DNS query with the POST method of RFC 8484
+
+Args:
+
+	msg: the query
+
+Returns:
+
+	the reply
+*/
+func (r *dohResolver) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	query := msg.Copy()
+	query.Id = 0 // RFC 8484 recommends an ID of 0 so that answers can be cached by HTTP caches
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", r.url, resp.Status)
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("%s: %w", r.url, err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,80 @@
+/*
+
+dot.go
+
+Support for -dot, which resolves host names with DNS over TLS (RFC 7858) instead of the system resolver,
+such as: -dot 1.1.1.1:853. The certificate of the server is verified against its address, or against the
+name given after a #, such as: -dot 9.9.9.9#dns.quad9.net. See encryptedResolver in dns.go.
+
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the port of DNS over TLS servers, when none is given
+const dotPort = "853"
+
+// A DNS over TLS server
+type dotResolver struct {
+	address string // host:port
+	client  *dns.Client
+}
+
+/*
+newDoTResolver parses the value given to -dot
+
+Args:
+
+	server: host, host:port, or either followed by # and the name on the server's certificate
+
+Returns:
+
+	a pointer to a dotResolver struct
+*/
+func newDoTResolver(server string) (*dotResolver, error) {
+	address, serverName, _ := strings.Cut(server, "#")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), dotPort)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || len(host) == 0 {
+		return nil, fmt.Errorf("invalid -dot server: %s; expected such as 1.1.1.1:853 or 9.9.9.9#dns.quad9.net", server)
+	}
+	if len(serverName) == 0 {
+		serverName = host
+	}
+	client := &dns.Client{
+		Net:       "tcp-tls",
+		Timeout:   10 * time.Second,
+		TLSConfig: &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12},
+	}
+	return &dotResolver{address: address, client: client}, nil
+}
+
+/*
+exchange sends a DNS query over TLS
+
+Args:
+
+	msg: the query
+
+Returns:
+
+	the reply
+*/
+func (r *dotResolver) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	reply, _, err := r.client.Exchange(msg, r.address)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.address, err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,325 @@
+/*
+
+drift.go
+
+The drift subcommand snapshots the saved target sets into the history and alerts when the country, ASN or
+IP addresses of a target drift from its baseline:
+
+	ipinfo -history redis://host:6379/0 drift prod staging
+	ipinfo -history redis://host:6379/0 drift -every 24h -webhook https://hooks.slack.com/services/...
+
+The baseline of a target is its first run in the history, or with -baseline, its last run at or before
+that date. Each snapshot is appended to the history, so the first snapshot of a new target becomes its
+baseline. With -every, the subcommand keeps running and takes a snapshot at each interval; otherwise the
+exit status is 0 when nothing drifted, 1 when any target drifted and 2 when the check failed.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	driftNone   = 0
+	driftFound  = 1
+	driftFailed = 2
+)
+
+// A target whose country, ASN or IP addresses differ from its baseline
+type driftAlert struct {
+	Sets     []string  `json:"sets"`
+	Input    string    `json:"input"`
+	Field    string    `json:"field"`
+	Baseline string    `json:"baseline"`
+	Current  string    `json:"current"`
+	Since    time.Time `json:"since"`
+}
+
+func (alert driftAlert) String() string {
+	return fmt.Sprintf("%s: %s: %s drifted from %s (baseline %s) to %s", strings.Join(alert.Sets, ","), alert.Input,
+		alert.Field, alert.Baseline, alert.Since.Format("2006-01-02 15:04"), alert.Current)
+}
+
+/*
+baselineRuns returns the baseline run of each input in the history
+
+Args:
+
+	records: the history, as returned by historyStore.load
+
+	at: when zero, the first run of each input is its baseline, otherwise its last run at or before this time
+
+Returns:
+
+	a map with key=input, value=the rows of its baseline run
+
+	a map with key=input, value=the time of its baseline run
+*/
+func baselineRuns(records []historyRecord, at time.Time) (map[string][]resultRow, map[string]time.Time) {
+	runs := make(map[string][]resultRow)
+	times := make(map[string]time.Time)
+	for _, record := range records {
+		if !at.IsZero() && record.Time.After(at) {
+			continue
+		}
+		chosen, ok := times[record.Input]
+		switch {
+		case !ok, at.IsZero() && record.Time.Before(chosen), !at.IsZero() && record.Time.After(chosen):
+			times[record.Input] = record.Time
+			runs[record.Input] = []resultRow{record.resultRow}
+		case record.Time.Equal(chosen):
+			runs[record.Input] = append(runs[record.Input], record.resultRow)
+		}
+	}
+	return runs, times
+}
+
+/*
+compareBaseline compares the current rows of each input with its baseline run
+
+Args:
+
+	records: the history, as returned by historyStore.load
+
+	rows: the rows of the current snapshot
+
+	at: the -baseline time, or zero for the first run of each input
+
+	sets: a map with key=input, value=the names of the target sets containing it
+
+Returns:
+
+	a slice of driftAlert structs, sorted by input and field
+*/
+func compareBaseline(records []historyRecord, rows []resultRow, at time.Time, sets map[string][]string) []driftAlert {
+	baseline, since := baselineRuns(records, at)
+	current := make(map[string][]resultRow)
+	for _, row := range rows {
+		current[row.Input] = append(current[row.Input], row)
+	}
+
+	fields := []struct {
+		name  string
+		value func(resultRow) string
+	}{
+		{"country", func(row resultRow) string { return row.Country }},
+		{"ASN", func(row resultRow) string { return asNumber(row.Org) }},
+		{"IP addresses", func(row resultRow) string { return row.Ip }},
+	}
+	var alerts []driftAlert
+	for input, now := range current {
+		before, ok := baseline[input]
+		if !ok {
+			continue
+		}
+		for _, field := range fields {
+			if a, b := distinctValues(before, field.value), distinctValues(now, field.value); a != b {
+				alerts = append(alerts, driftAlert{Sets: sets[input], Input: input, Field: field.name, Baseline: a, Current: b, Since: since[input]})
+			}
+		}
+	}
+	sort.Slice(alerts, func(a, b int) bool {
+		if alerts[a].Input != alerts[b].Input {
+			return alerts[a].Input < alerts[b].Input
+		}
+		return alerts[a].Field < alerts[b].Field
+	})
+	return alerts
+}
+
+/*
+snapshotTargetSets looks up the targets of the given target sets
+
+Args:
+
+	store: the target sets
+
+	names: the names of the target sets to look up; when empty, all target sets are looked up
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	the rows of the snapshot, one per input and IP address; failed lookups are skipped
+
+	a map with key=input, value=the names of the target sets containing it
+*/
+func snapshotTargetSets(store targetSetStore, names []string, workers int, cache ipCache) ([]resultRow, map[string][]string, error) {
+	all, err := store.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names) == 0 {
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no target sets found; add them with: ipinfo targets add <name> <hosts...>")
+	}
+
+	sets := make(map[string][]string)
+	var hostnames []string
+	for _, name := range names {
+		targets, ok := all[strings.TrimPrefix(name, "@")]
+		if !ok {
+			return nil, nil, fmt.Errorf("target set not found: %s", name)
+		}
+		for _, hostname := range normalizedTargets(targets) {
+			if !stringInSlice(hostname, hostnames) {
+				hostnames = append(hostnames, hostname)
+			}
+			sets[hostname] = append(sets[hostname], strings.TrimPrefix(name, "@"))
+		}
+	}
+
+	ipAddrs, _, _, hostAddrs := runDNSHosts(workers, hostnames, false)
+	ipInfo, err := resolveAllIpInfoFunc(workers, ipAddrs, cache, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	infos := make(map[string]ipInfoResult, len(ipInfo))
+	for _, info := range ipInfo {
+		infos[info.Ip] = info
+	}
+	// an address shared by several targets belongs to each of them, so the rows are built from hostAddrs
+	var rows []resultRow
+	for _, hostname := range hostnames {
+		for _, ip := range hostAddrs[hostname] {
+			if row := (resultRow{Input: hostname, ipInfoResult: infos[ip]}); lookupSucceeded(row) {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, sets, nil
+}
+
+/*
+checkDrift takes a snapshot of the target sets, compares it with the baselines and appends it to the history
+
+Args:
+
+	history: the history
+
+	store: the target sets
+
+	names: the names of the target sets to check, or empty for all
+
+	at: the -baseline time, or zero
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	the drifted targets
+*/
+func checkDrift(history historyStore, store targetSetStore, names []string, at time.Time, workers int, cache ipCache) ([]driftAlert, error) {
+	records, err := history.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rows, sets, err := snapshotTargetSets(store, names, workers, cache)
+	if err != nil {
+		return nil, err
+	}
+	alerts := compareBaseline(records, rows, at, sets)
+	if err := appendHistory(history, rows, now); err != nil {
+		return alerts, err
+	}
+	return alerts, nil
+}
+
+/*
+runDrift implements the drift subcommand
+
+Args:
+
+	args: the command line arguments following "drift"
+
+	dsn: the -history value, which holds the snapshots and the target sets
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	the program's exit status
+*/
+func runDrift(args []string, dsn string, workers int, cache ipCache) int {
+	flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+	everyFlag := flags.Duration("every", envDuration("IPINFO_DRIFT_EVERY", 0), "keep running and take a snapshot at this interval, such as 24h; env: IPINFO_DRIFT_EVERY")
+	baselineFlag := flags.String("baseline", "", "compare with the last run at or before this date, such as 2024-05-31, instead of the first run")
+	webhookFlag := flags.String("webhook", envString("IPINFO_WEBHOOK", ""), "also post each drifted target as JSON {\"text\": ...} to this URL, such as a Slack or Mattermost incoming webhook; env: IPINFO_WEBHOOK")
+	jsonFlag := flags.Bool("json", false, "output each drifted target as a JSON line")
+	if err := flags.Parse(args); err != nil {
+		return driftFailed
+	}
+	if len(dsn) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ipinfo -history <file or DSN> drift [-every 24h] [target sets...]")
+		return driftFailed
+	}
+	var at time.Time
+	if len(*baselineFlag) > 0 {
+		day, err := time.ParseInLocation("2006-01-02", *baselineFlag, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -baseline date: %s\n", *baselineFlag)
+			return driftFailed
+		}
+		// the whole day is included
+		at = day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	history, err := openHistory(dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return driftFailed
+	}
+	store, err := openTargetSets(dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return driftFailed
+	}
+
+	for {
+		alerts, err := checkDrift(history, store, flags.Args(), at, workers, cache)
+		for _, alert := range alerts {
+			if *jsonFlag {
+				json.NewEncoder(os.Stdout).Encode(alert)
+			} else {
+				fmt.Println(alert)
+			}
+			if len(*webhookFlag) > 0 {
+				if err := postWebhook(*webhookFlag, "ipinfo drift: "+alert.String()); err != nil {
+					fmt.Fprintln(os.Stderr, "warning:", err)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if *everyFlag <= 0 {
+			switch {
+			case err != nil:
+				return driftFailed
+			case len(alerts) > 0:
+				return driftFound
+			}
+			return driftNone
+		}
+		time.Sleep(*everyFlag)
+	}
+}
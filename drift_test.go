@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckDrift(t *testing.T) {
+	dir := t.TempDir()
+	history := fileHistory{fname: filepath.Join(dir, "history.jsonl")}
+	store := fileTargetSets{fname: filepath.Join(dir, "targets.json")}
+	if err := store.set("prod", []string{"a.example", "https://b.example/login"}); err != nil {
+		t.Fatal(err)
+	}
+
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{})
+	checkGoroutines(t, func() {
+		// the first round records the baseline, the following rounds find the same results
+		for i := 0; i < 3; i++ {
+			alerts, err := checkDrift(history, store, nil, time.Time{}, 30, nil)
+			if err != nil || len(alerts) != 0 {
+				t.Fatalf("round %d: %v, %v", i, alerts, err)
+			}
+		}
+	})
+
+	useFakes(t, fakeResolver{addr: "192.0.2.2"}, fakeIpinfo{})
+	alerts, err := checkDrift(history, store, []string{"@prod"}, time.Time{}, 30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].Input != "a.example" || alerts[1].Input != "b.example" || alerts[0].Field != "IP addresses" {
+		t.Errorf("unexpected alerts: %v", alerts)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+
+email.go
+
+Support for -email-headers, which reads the raw headers of an email message from standard input and
+geolocates the IP address of each Received: hop, showing the path the message took from its origin
+to the final mail server. Each mail server adds its Received: header at the top, so the headers are
+read from the bottom up. Only the hops added by your own mail servers can be trusted; everything
+below them may have been forged by the sender.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An IP address in brackets, such as [192.0.2.1] or [IPv6:2001:db8::1], as added by most mail servers
+var receivedBracketIP = regexp.MustCompile(`\[(?i:IPv6:)?([0-9A-Fa-f:.]+)\]`)
+
+// Any IPv4 address, for mail servers that do not use brackets
+var receivedIPv4 = regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`)
+
+// A single Received: header
+type receivedHop struct {
+	from string // the host name the sending server gave
+	by   string // the host name of the receiving server
+	ip   string // the IP address of the sending server, or empty when not found
+}
+
+/*
+readReceivedHeaders reads the Received: headers of an email message, unfolding continuation lines
+
+Args:
+
+	r: the raw message or just its headers; reading stops at the first blank line
+
+Returns:
+
+	the value of each Received: header, from the top down
+*/
+func readReceivedHeaders(r io.Reader) ([]string, error) {
+	var headers []string
+	var current string
+	flush := func() {
+		if name, value, found := strings.Cut(current, ":"); found && strings.EqualFold(strings.TrimSpace(name), "Received") {
+			headers = append(headers, strings.TrimSpace(value))
+		}
+		current = ""
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			if len(current) > 0 {
+				break // the end of the headers
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			current += " " + strings.TrimSpace(line)
+			continue
+		}
+		flush()
+		current = line
+	}
+	flush()
+	return headers, scanner.Err()
+}
+
+/*
+parseReceived extracts the sending server of a Received: header
+
+Args:
+
+	header: the value of a Received: header, such as:
+	from mail.example.com (mail.example.com [192.0.2.1]) by mx.example.net with ESMTPS id ...; date
+
+Returns:
+
+	a receivedHop struct
+*/
+func parseReceived(header string) receivedHop {
+	var hop receivedHop
+	header, _, _ = strings.Cut(header, ";") // the date
+	fields := strings.Fields(header)
+	for i := 0; i+1 < len(fields); i++ {
+		switch strings.ToLower(fields[i]) {
+		case "from":
+			if len(hop.from) == 0 {
+				hop.from = fields[i+1]
+			}
+		case "by":
+			if len(hop.by) == 0 {
+				hop.by = fields[i+1]
+			}
+		}
+	}
+
+	// the address of the sender is in the from clause, before "by"
+	fromClause := header
+	if index := strings.Index(strings.ToLower(header), " by "); index >= 0 {
+		fromClause = header[:index]
+	}
+	// the address the server saw is in parentheses; the one before them is given by the sender in HELO
+	candidates := []string{fromClause}
+	if index := strings.Index(fromClause, "("); index >= 0 {
+		candidates = []string{fromClause[index:], fromClause}
+	}
+	for _, candidate := range candidates {
+		for _, match := range receivedBracketIP.FindAllStringSubmatch(candidate, -1) {
+			if ip := net.ParseIP(match[1]); ip != nil {
+				hop.ip = ip.String()
+				return hop
+			}
+		}
+	}
+	for _, match := range receivedIPv4.FindAllString(fromClause, -1) {
+		if net.ParseIP(match) != nil {
+			hop.ip = match
+			return hop
+		}
+	}
+	return hop
+}
+
+/*
+runEmailHeaders geolocates the Received: hops of an email message read from standard input
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an error if the headers could not be read or contain no Received: headers
+*/
+func runEmailHeaders(workers int, cache ipCache) error {
+	headers, err := readReceivedHeaders(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return fmt.Errorf("no Received: headers found in standard input")
+	}
+
+	// the bottom header is the first hop
+	hops := make([]receivedHop, len(headers))
+	var ipAddrs []string
+	for i, header := range headers {
+		hop := parseReceived(header)
+		hops[len(headers)-1-i] = hop
+		if isPublicIP(hop.ip) && !stringInSlice(hop.ip, ipAddrs) {
+			ipAddrs = append(ipAddrs, hop.ip)
+		}
+	}
+	infos := make(map[string]ipInfoResult)
+	for _, info := range resolveAllIpInfo(workers, ipAddrs, cache) {
+		infos[info.Ip] = info
+	}
+
+	var allRows [][]string
+	previous := ""
+	for i, hop := range hops {
+		row := []string{strconv.Itoa(i + 1), orNA(hop.from), orNA(hop.by), orNA(hop.ip)}
+		info, ok := infos[hop.ip]
+		switch {
+		case ok:
+			row = append(row, orNA(info.Org), orNA(info.City), orNA(info.Country))
+		case len(hop.ip) > 0:
+			row = append(row, "private", "", "")
+		default:
+			row = append(row, "", "", "")
+		}
+		distanceStr := ""
+		if ok && hasLocation(info.Loc) {
+			if hasLocation(previous) {
+				lat1, lon1 := latlon2coord(previous)
+				lat2, lon2 := latlon2coord(info.Loc)
+				distanceStr = fmt.Sprintf("%.2f", HaversineDistance(lat1, lon1, lat2, lon2))
+			}
+			previous = info.Loc
+		}
+		allRows = append(allRows, append(row, distanceStr))
+	}
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"Hop", "From", "By", "IP", "Org", "City", "Country", "Miles"})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
@@ -0,0 +1,308 @@
+/*
+
+external.go
+
+Support for -x and -x6, which display the external IPv4 or IPv6 address of this machine. The address is
+detected with several independent methods at once, so that one failing service does not matter and so that
+split tunnels, proxies and multiple NAT gateways are noticed when the methods disagree:
+
+	https  the address seen by web services: ipinfo.io, ipify.org and icanhazip.com
+	dns    the address seen by the authoritative name servers of Google and Akamai
+	stun   the address seen by the STUN servers of Google and Cloudflare, as used by WebRTC
+
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const externalTimeout = 5 * time.Second
+
+// A method of detecting the external IP address; network is tcp4 or tcp6
+type externalMethod struct {
+	name   string
+	detect func(ctx context.Context, network string) (string, error)
+}
+
+var externalMethods = []externalMethod{
+	{"https ipinfo.io", httpsExternalIP("https://ipinfo.io/ip")},
+	{"https ipify.org", httpsExternalIP("https://api64.ipify.org")},
+	{"https icanhazip.com", httpsExternalIP("https://icanhazip.com")},
+	{"dns google", dnsExternalIP("ns1.google.com", "o-o.myaddr.l.google.com", dns.TypeTXT)},
+	{"dns akamai", dnsExternalIP("ns1-1.akamaitech.net", "whoami.akamai.net", 0)},
+	{"stun google", stunExternalIP("stun.l.google.com:19302")},
+	{"stun cloudflare", stunExternalIP("stun.cloudflare.com:3478")},
+}
+
+// ipVersion returns IPv4 for tcp4 and IPv6 for tcp6
+func ipVersion(network string) string {
+	if network == "tcp6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+/*
+checkFamily verifies that an address is of the IP version of network
+
+Args:
+
+	ip: the detected address
+
+	network: tcp4 or tcp6
+
+Returns:
+
+	the address in canonical form
+*/
+func checkFamily(ip string, network string) (string, error) {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return "", fmt.Errorf("invalid address: %q", ip)
+	}
+	if (addr.To4() != nil) != (network == "tcp4") {
+		return "", fmt.Errorf("%s is not an %s address", addr, ipVersion(network))
+	}
+	return addr.String(), nil
+}
+
+/*
+httpsExternalIP returns a method that reads the address from a web service that answers with it as plain text
+
+Args:
+
+	url: the URL of the web service
+
+Returns:
+
+	the detect function of an externalMethod
+*/
+func httpsExternalIP(url string) func(ctx context.Context, network string) (string, error) {
+	return func(ctx context.Context, network string) (string, error) {
+		dialer := &net.Dialer{}
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s: %s", url, resp.Status)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+		if err != nil {
+			return "", err
+		}
+		return checkFamily(string(body), network)
+	}
+}
+
+/*
+dnsExternalIP returns a method that asks an authoritative name server for a name that resolves to the
+address of the client
+
+Args:
+
+	server: the host name of the authoritative name server
+
+	name: the name to look up
+
+	qtype: dns.TypeTXT when the address is in a TXT record, or 0 for an A or AAAA record
+
+Returns:
+
+	the detect function of an externalMethod
+*/
+func dnsExternalIP(server string, name string, qtype uint16) func(ctx context.Context, network string) (string, error) {
+	return func(ctx context.Context, network string) (string, error) {
+		ipNetwork, udpNetwork, recordType := "ip4", "udp4", qtype
+		if network == "tcp6" {
+			ipNetwork, udpNetwork = "ip6", "udp6"
+		}
+		if recordType == 0 {
+			recordType = map[string]uint16{"tcp4": dns.TypeA, "tcp6": dns.TypeAAAA}[network]
+		}
+		addrs, err := net.DefaultResolver.LookupIP(ctx, ipNetwork, server)
+		if err != nil {
+			return "", err
+		}
+
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(name), recordType)
+		client := &dns.Client{Net: udpNetwork}
+		reply, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(addrs[0].String(), "53"))
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range reply.Answer {
+			switch record := rr.(type) {
+			case *dns.TXT:
+				return checkFamily(strings.Join(record.Txt, ""), network)
+			case *dns.A:
+				return checkFamily(record.A.String(), network)
+			case *dns.AAAA:
+				return checkFamily(record.AAAA.String(), network)
+			}
+		}
+		return "", fmt.Errorf("%s: no answer from %s", name, server)
+	}
+}
+
+/*
+stunExternalIP returns a method that sends a STUN binding request, see RFC 5389
+
+Args:
+
+	server: the host name and port of the STUN server
+
+Returns:
+
+	the detect function of an externalMethod
+*/
+func stunExternalIP(server string) func(ctx context.Context, network string) (string, error) {
+	return func(ctx context.Context, network string) (string, error) {
+		const magicCookie = 0x2112A442
+		udpNetwork := strings.Replace(network, "tcp", "udp", 1)
+		dialer := &net.Dialer{}
+		conn, err := dialer.DialContext(ctx, udpNetwork, server)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+
+		request := make([]byte, 20)
+		binary.BigEndian.PutUint16(request[0:], 0x0001) // binding request, without attributes
+		binary.BigEndian.PutUint32(request[4:], magicCookie)
+		if _, err := rand.Read(request[8:20]); err != nil {
+			return "", err
+		}
+		if _, err := conn.Write(request); err != nil {
+			return "", err
+		}
+		response := make([]byte, 1500)
+		n, err := conn.Read(response)
+		if err != nil {
+			return "", err
+		}
+		response = response[:n]
+		if n < 20 || binary.BigEndian.Uint16(response[0:]) != 0x0101 || string(response[8:20]) != string(request[8:20]) {
+			return "", fmt.Errorf("%s: invalid STUN response", server)
+		}
+
+		// XOR-MAPPED-ADDRESS is preferred over MAPPED-ADDRESS, which some NATs rewrite
+		var mapped net.IP
+		for attrs := response[20:]; len(attrs) >= 4; {
+			attrType, length := binary.BigEndian.Uint16(attrs[0:]), int(binary.BigEndian.Uint16(attrs[2:]))
+			if len(attrs) < 4+length {
+				break
+			}
+			value := attrs[4 : 4+length]
+			if (attrType == 0x0020 || attrType == 0x0001) && length >= 8 {
+				addr := append(net.IP{}, value[4:]...)
+				if attrType == 0x0020 {
+					key := response[4:20] // the magic cookie followed by the transaction id
+					for i := range addr {
+						addr[i] ^= key[i]
+					}
+				}
+				if len(addr) == net.IPv4len || len(addr) == net.IPv6len {
+					mapped = addr
+					if attrType == 0x0020 {
+						break
+					}
+				}
+			}
+			if padded := 4 + (length+3)/4*4; padded < len(attrs) { // attributes are padded to 4 bytes
+				attrs = attrs[padded:]
+			} else {
+				break
+			}
+		}
+		if mapped == nil {
+			return "", fmt.Errorf("%s: no mapped address in STUN response", server)
+		}
+		return checkFamily(mapped.String(), network)
+	}
+}
+
+/*
+detectExternalIP runs all external IP methods concurrently
+
+Args:
+
+	network: tcp4 for the IPv4 address, or tcp6 for the IPv6 address
+
+Returns:
+
+	the address returned by the most methods, or an error when all methods failed
+
+	warnings listing the answer of each method when they disagree
+*/
+func detectExternalIP(network string) (string, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalTimeout)
+	defer cancel()
+
+	answers := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, method := range externalMethods {
+		wg.Add(1)
+		go func(method externalMethod) {
+			defer wg.Done()
+			if ip, err := method.detect(ctx, network); err == nil {
+				mu.Lock()
+				answers[method.name] = ip
+				mu.Unlock()
+			}
+		}(method)
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	best := ""
+	for _, method := range externalMethods { // in method order, so that ties are broken the same way each time
+		if ip, ok := answers[method.name]; ok {
+			if counts[ip]++; counts[ip] > counts[best] {
+				best = ip
+			}
+		}
+	}
+	if len(best) == 0 {
+		return "", nil, fmt.Errorf("no external %s address found", ipVersion(network))
+	}
+	var warnings []string
+	if len(counts) > 1 {
+		var differ []string
+		for name, ip := range answers {
+			differ = append(differ, name+": "+ip)
+		}
+		sort.Strings(differ)
+		warnings = append(warnings, "the external IP detection methods disagree, which may be caused by a split tunnel or proxy: "+strings.Join(differ, ", "))
+	}
+	return best, warnings, nil
+}
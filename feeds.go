@@ -0,0 +1,392 @@
+/*
+
+feeds.go
+
+Support for -feeds, which tags every result with the public threat feeds its IP address is listed in.
+CSV feeds, such as the abuse.ch Feodo Tracker and SSLBL botnet C2 lists, are read by the column names
+of their last comment line, and the malware family and first seen date are shown with each match.
+Feeds are downloaded to the user's cache directory and downloaded again once they are older than
+-feeds-refresh; serve refreshes them in the background on the same schedule. The networks of all feeds
+are kept in a binary radix tree, so that a lookup costs at most 32 or 128 steps however large the feeds are.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The feeds that can be given by name; others are given as name=url
+var builtinFeeds = map[string]string{
+	"spamhaus-drop":   "https://www.spamhaus.org/drop/drop.txt",
+	"spamhaus-dropv6": "https://www.spamhaus.org/drop/dropv6.txt",
+	"et-compromised":  "https://rules.emergingthreats.net/blockrules/compromised-ips.txt",
+	"firehol-level1":  "https://iplists.firehol.org/files/firehol_level1.netset",
+	"feodo":           "https://feodotracker.abuse.ch/downloads/ipblocklist.csv",
+	"sslbl":           "https://sslbl.abuse.ch/blacklist/sslipblacklist.csv",
+}
+
+// feed names are also file names, so they are limited to these characters
+var feedName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// A threat feed: a list of IP addresses and networks, one per line
+type threatFeed struct {
+	name string
+	url  string
+}
+
+// A node of the radix tree; feeds holds the feeds that list the network ending at this node
+type feedNode struct {
+	children [2]*feedNode
+	feeds    []string
+}
+
+// The networks of all feeds, with one tree per IP version
+type feedIndex struct {
+	v4 feedNode
+	v6 feedNode
+}
+
+/*
+parseFeeds parses the value given to -feeds
+
+Args:
+
+	list: a comma separated list of builtin feed names and name=url pairs,
+	such as: spamhaus-drop,blocklist=https://example.com/blocklist.txt
+
+Returns:
+
+	a slice of threatFeed structs
+*/
+func parseFeeds(list string) ([]threatFeed, error) {
+	var feeds []threatFeed
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		name, url, custom := strings.Cut(entry, "=")
+		name = strings.ToLower(name)
+		if !custom {
+			var ok bool
+			if url, ok = builtinFeeds[name]; !ok {
+				var names []string
+				for builtin := range builtinFeeds {
+					names = append(names, builtin)
+				}
+				sort.Strings(names)
+				return nil, fmt.Errorf("unknown feed: %s; use one of %s, or name=url", name, strings.Join(names, ", "))
+			}
+		}
+		if !feedName.MatchString(name) {
+			return nil, fmt.Errorf("invalid feed name: %s", name)
+		}
+		feeds = append(feeds, threatFeed{name, url})
+	}
+	if len(feeds) == 0 {
+		return nil, fmt.Errorf("no feeds given")
+	}
+	return feeds, nil
+}
+
+/*
+insert adds the network of a feed to the index
+
+Args:
+
+	prefix: an IP address or network
+
+	feed: the name of the feed that lists it, with the details of the entry
+*/
+func (index *feedIndex) insert(prefix netip.Prefix, feed string) {
+	addr := prefix.Addr().Unmap()
+	node := &index.v6
+	if addr.Is4() {
+		node = &index.v4
+	}
+	bytes := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := (bytes[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &feedNode{}
+		}
+		node = node.children[bit]
+	}
+	if !stringInSlice(feed, node.feeds) {
+		node.feeds = append(node.feeds, feed)
+	}
+}
+
+/*
+lookup finds all feeds that list an IP address or a network containing it
+
+Args:
+
+	ip: an IP address
+
+Returns:
+
+	the sorted names of the feeds, such as: feodo (QakBot first seen 2023-01-02);
+	nil when the index is nil or no feed lists the address
+*/
+func (index *feedIndex) lookup(ip string) []string {
+	addr, err := netip.ParseAddr(ip)
+	if index == nil || err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	node := &index.v6
+	if addr.Is4() {
+		node = &index.v4
+	}
+	var feeds []string
+	bytes := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		for _, feed := range node.feeds {
+			if !stringInSlice(feed, feeds) {
+				feeds = append(feeds, feed)
+			}
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		node = node.children[(bytes[i/8]>>(7-i%8))&1]
+	}
+	sort.Strings(feeds)
+	return feeds
+}
+
+/*
+csvFeedEntry reads a line of a CSV feed, such as the abuse.ch line:
+"2021-01-17 07:44:46","51.178.161.32","4643","online","2024-01-10","Dridex"
+
+Args:
+
+	line: the CSV line
+
+	columns: the normalized column names of the feed, such as firstseenutc, dstip and malware; may be empty
+
+Returns:
+
+	the IP address or network, and the malware family and first seen date, or an empty string
+*/
+func csvFeedEntry(line string, columns []string) (string, string) {
+	fields := strings.Split(line, ",")
+	var value, malware, firstSeen string
+	for i, field := range fields {
+		field = strings.Trim(strings.TrimSpace(field), `"`)
+		column := ""
+		if i < len(columns) {
+			column = columns[i]
+		}
+		switch {
+		case column == "dstip" || column == "ip" || column == "ipaddress":
+			value = field
+		case len(value) == 0 && len(column) == 0 && (net.ParseIP(field) != nil || strings.Contains(field, "/")):
+			value = field
+		case column == "malware":
+			malware = field
+		case strings.HasPrefix(column, "firstseen"):
+			firstSeen, _, _ = strings.Cut(field, " ") // only the date
+		}
+	}
+	detail := malware
+	if len(firstSeen) > 0 {
+		detail = strings.TrimSpace(detail + " first seen " + firstSeen)
+	}
+	return value, detail
+}
+
+/*
+readFeed adds every network of a feed file to the index; comments after # or ; are ignored
+
+Args:
+
+	r: the feed file
+
+	feed: the name of the feed
+
+	index: the index to add to
+*/
+func readFeed(r io.Reader, feed string, index *feedIndex) error {
+	var comment string
+	var columns []string // the columns of a CSV feed, named by the comment line before the first entry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			comment = line[1:]
+			continue
+		}
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		var value, detail string
+		if strings.Contains(line, ",") {
+			if columns == nil {
+				columns = []string{}
+				for _, name := range strings.Split(comment, ",") {
+					name = strings.ToLower(strings.Trim(strings.TrimSpace(name), `"`))
+					columns = append(columns, strings.NewReplacer("_", "", " ", "").Replace(name))
+				}
+			}
+			value, detail = csvFeedEntry(line, columns)
+		} else if fields := strings.Fields(line); len(fields) > 0 {
+			value = fields[0]
+		}
+		if len(value) == 0 {
+			continue
+		}
+		label := feed
+		if len(detail) > 0 {
+			label += " (" + detail + ")"
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		if !prefix.IsValid() {
+			continue
+		}
+		index.insert(prefix.Masked(), label)
+	}
+	return scanner.Err()
+}
+
+/*
+feedsDir returns the directory that feeds are downloaded to
+
+Returns:
+
+	the feeds directory in the user's cache directory, or in the temporary directory
+*/
+func feedsDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ipinfo", "feeds")
+}
+
+/*
+downloadFeed downloads a feed, replacing the previous download only once the new one is complete
+
+Args:
+
+	feed: the feed to download
+
+	fname: the file to save it to
+*/
+func downloadFeed(feed threatFeed, fname string) error {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(feed.url)
+	if err != nil {
+		return fmt.Errorf("feed %s: %w", feed.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("feed %s: %s", feed.name, resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fname), feed.name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.LimitReader(resp.Body, 256*1024*1024))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("feed %s: %w", feed.name, err)
+	}
+	return os.Rename(tmp.Name(), fname)
+}
+
+/*
+loadFeeds builds the index of all feeds, downloading the feeds that are missing or older than refresh;
+when a download fails, the previous download is used
+
+Args:
+
+	feeds: the feeds to load
+
+	refresh: how old a download may be before it is downloaded again
+
+Returns:
+
+	the index, which holds the feeds that could be loaded
+
+	warnings for the feeds that could not be downloaded or read
+*/
+func loadFeeds(feeds []threatFeed, refresh time.Duration) (*feedIndex, []error) {
+	index := &feedIndex{}
+	var warnings []error
+	for _, feed := range feeds {
+		fname := filepath.Join(feedsDir(), feed.name+".txt")
+		if stat, err := os.Stat(fname); err != nil || time.Since(stat.ModTime()) > refresh {
+			if err := downloadFeed(feed, fname); err != nil {
+				warnings = append(warnings, err)
+			}
+		}
+		file, err := os.Open(fname)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				warnings = append(warnings, err)
+			}
+			continue
+		}
+		err = readFeed(file, feed.name, index)
+		file.Close()
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("feed %s: %w", feed.name, err))
+		}
+	}
+	return index, warnings
+}
+
+/*
+refreshFeedsEvery loads the feeds and then loads them again every interval, replacing the index each time;
+it never returns
+
+Args:
+
+	feeds: the feeds to load
+
+	interval: the time between refreshes, which is also the age at which a download is replaced
+
+	index: the index that lookups use
+*/
+func refreshFeedsEvery(feeds []threatFeed, interval time.Duration, index *atomic.Pointer[feedIndex]) {
+	for {
+		loaded, warnings := loadFeeds(feeds, interval)
+		for _, warning := range warnings {
+			fmt.Fprintln(os.Stderr, "warning:", warning)
+		}
+		index.Store(loaded)
+		time.Sleep(interval)
+	}
+}
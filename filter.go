@@ -0,0 +1,403 @@
+/*
+
+filter.go
+
+Support for -filter, which only outputs the results matching an expression such as:
+
+	country == "US" && dist > 500
+
+Fields are named as in the JSON output, with dist as a short name for distance.
+Comparisons are: == != < <= > >= and =~ for regular expressions; string equality ignores case.
+Comparisons can be combined with && || ! and parentheses. Comparisons with an unknown value,
+such as the distance of an IP address without a location, are false.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A compiled -filter expression
+type rowFilter func(row resultRow) bool
+
+// The kinds of tokens in a filter expression
+const (
+	tokenField = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenEnd
+)
+
+// A single token of a filter expression
+type filterToken struct {
+	kind  int
+	text  string
+	value float64
+}
+
+// A recursive descent parser for filter expressions
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")"}
+
+/*
+tokenizeFilter splits a filter expression into tokens
+
+Args:
+
+	expr: the filter expression
+
+Returns:
+
+	a slice of tokens ending with a tokenEnd token
+*/
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			text := string(runes[i+1 : end])
+			text = strings.NewReplacer(`\`+string(r), string(r), `\\`, `\`).Replace(text)
+			tokens = append(tokens, filterToken{kind: tokenString, text: text})
+			i = end + 1
+		case unicode.IsDigit(r) || r == '.' || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			value, err := strconv.ParseFloat(string(runes[i:end]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number at position %d: %s", i+1, string(runes[i:end]))
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: string(runes[i:end]), value: value})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenField, text: string(runes[i:end])})
+			i = end
+		default:
+			found := false
+			for _, op := range filterOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, filterToken{kind: tokenOperator, text: op})
+					i += len([]rune(op))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character at position %d: %c", i+1, r)
+			}
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEnd, text: "end of expression"}), nil
+}
+
+/*
+parseFilter compiles a filter expression
+
+Args:
+
+	expr: the value of -filter, such as: country == "US" && dist > 500
+
+Returns:
+
+	a function that reports whether a row matches the expression
+*/
+func parseFilter(expr string) (rowFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("-filter: %w", err)
+	}
+	parser := &filterParser{tokens: tokens}
+	filter, err := parser.parseOr()
+	if err == nil && parser.peek().kind != tokenEnd {
+		err = fmt.Errorf("unexpected %s", parser.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-filter: %w", err)
+	}
+	return filter, nil
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+// accept consumes the next token when it is the given operator
+func (p *filterParser) accept(op string) bool {
+	if token := p.peek(); token.kind == tokenOperator && token.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (rowFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(row resultRow) bool { return a(row) || b(row) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (rowFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(row resultRow) bool { return a(row) && b(row) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (rowFilter, error) {
+	if p.accept("!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(row resultRow) bool { return !inner(row) }, nil
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("expected ) instead of %s", p.peek().text)
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (rowFilter, error) {
+	fieldToken := p.next()
+	if fieldToken.kind != tokenField {
+		return nil, fmt.Errorf("expected a field name instead of %s", fieldToken.text)
+	}
+	index, err := filterField(fieldToken.text)
+	if err != nil {
+		return nil, err
+	}
+	opToken := p.next()
+	if opToken.kind != tokenOperator || !stringInSlice(opToken.text, []string{"==", "!=", "<", "<=", ">", ">=", "=~"}) {
+		return nil, fmt.Errorf("expected a comparison after %s instead of %s", fieldToken.text, opToken.text)
+	}
+	op := opToken.text
+	literal := p.next()
+
+	fieldType := reflect.TypeOf(resultRow{}).FieldByIndex(index).Type
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	value := func(row resultRow) (reflect.Value, bool) {
+		v := reflect.ValueOf(row).FieldByIndex(index)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		return v, true
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		if literal.kind != tokenString {
+			return nil, fmt.Errorf("%s must be compared with a quoted string", fieldToken.text)
+		}
+		if op == "=~" {
+			re, err := regexp.Compile(literal.text)
+			if err != nil {
+				return nil, err
+			}
+			return func(row resultRow) bool {
+				v, _ := value(row)
+				return re.MatchString(v.String())
+			}, nil
+		}
+		return func(row resultRow) bool {
+			v, _ := value(row)
+			return compareStrings(v.String(), op, literal.text)
+		}, nil
+	case reflect.Slice:
+		if literal.kind != tokenString || (op != "==" && op != "!=") {
+			return nil, fmt.Errorf("%s can only be compared with == or != and a quoted string", fieldToken.text)
+		}
+		return func(row resultRow) bool {
+			v, _ := value(row)
+			found := false
+			for i := 0; i < v.Len(); i++ {
+				found = found || strings.EqualFold(v.Index(i).String(), literal.text)
+			}
+			return found == (op == "==")
+		}, nil
+	case reflect.Float32, reflect.Float64, reflect.Uint32, reflect.Int:
+		if literal.kind != tokenNumber || op == "=~" {
+			return nil, fmt.Errorf("%s must be compared with a number", fieldToken.text)
+		}
+		return func(row resultRow) bool {
+			v, ok := value(row)
+			if !ok {
+				return false
+			}
+			number := 0.0
+			switch v.Kind() {
+			case reflect.Uint32:
+				number = float64(v.Uint())
+			case reflect.Int:
+				number = float64(v.Int())
+			default:
+				number = v.Float()
+			}
+			return compareNumbers(number, op, literal.value)
+		}, nil
+	}
+	return nil, fmt.Errorf("%s can not be filtered", fieldToken.text)
+}
+
+/*
+filterField finds a field of resultRow by its JSON name
+
+Args:
+
+	name: a JSON field name, or dist for distance
+
+Returns:
+
+	the index of the field, as used by reflect.Value.FieldByIndex
+*/
+func filterField(name string) ([]int, error) {
+	name = strings.ToLower(name)
+	if name == "dist" {
+		name = "distance"
+	}
+	var names []string
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(resultRow{})) {
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous || tag == "-" || len(tag) == 0 {
+			continue
+		}
+		if tag == name {
+			return field.Index, nil
+		}
+		names = append(names, tag)
+	}
+	return nil, fmt.Errorf("unknown field %s; known fields are: %s", name, strings.Join(names, ", "))
+}
+
+// compareStrings applies a comparison operator to two strings; == and != ignore case
+func compareStrings(a, op, b string) bool {
+	switch op {
+	case "==":
+		return strings.EqualFold(a, b)
+	case "!=":
+		return !strings.EqualFold(a, b)
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// compareNumbers applies a comparison operator to two numbers
+func compareNumbers(a float64, op string, b float64) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+/*
+filterRows returns the rows matching a filter
+
+Args:
+
+	rows: a slice of resultRow structs
+
+	filter: a filter as returned by parseFilter; when nil, all rows are returned
+
+Returns:
+
+	the matching rows, in the same order
+*/
+func filterRows(rows []resultRow, filter rowFilter) []resultRow {
+	if filter == nil {
+		return rows
+	}
+	var matched []resultRow
+	for _, row := range rows {
+		if filter(row) {
+			matched = append(matched, row)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,229 @@
+/*
+
+gateway.go
+
+Support for myip -gateway, which asks the local router for its external IPv4 address with NAT-PMP
+(RFC 6886) and UPnP IGD. When the router's external address is not the external address seen by the
+internet, there is a second NAT behind the router, such as CGNAT or another router (double NAT).
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const gatewayTimeout = 3 * time.Second
+
+/*
+defaultGateway reads the IPv4 default gateway from /proc/net/route; other systems are not supported
+
+Returns:
+
+	the IP address of the default gateway
+*/
+func defaultGateway() (net.IP, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// interface, destination, gateway, ...; addresses are little endian hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+	}
+	return nil, fmt.Errorf("no default gateway found")
+}
+
+/*
+natpmpExternalIP asks a gateway for its external address with NAT-PMP
+
+Args:
+
+	gateway: the IP address of the gateway
+
+Returns:
+
+	the external IPv4 address of the gateway
+*/
+func natpmpExternalIP(gateway net.IP) (string, error) {
+	conn, err := net.DialTimeout("udp4", net.JoinHostPort(gateway.String(), "5351"), gatewayTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(gatewayTimeout))
+	if _, err := conn.Write([]byte{0, 0}); err != nil { // version 0, external address request
+		return "", err
+	}
+	response := make([]byte, 16)
+	n, err := conn.Read(response)
+	if err != nil {
+		return "", fmt.Errorf("NAT-PMP: %w", err)
+	}
+	// version, opcode 128, result code, seconds since start of epoch, external address
+	if n < 12 || response[1] != 128 {
+		return "", fmt.Errorf("NAT-PMP: invalid response")
+	}
+	if result := binary.BigEndian.Uint16(response[2:]); result != 0 {
+		return "", fmt.Errorf("NAT-PMP: result code %d", result)
+	}
+	return net.IP(response[8:12]).String(), nil
+}
+
+/*
+upnpControlURL finds the WANIPConnection or WANPPPConnection service of an internet gateway device
+with an SSDP search
+
+Returns:
+
+	the control URL and service type of the service
+*/
+func upnpControlURL() (string, string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	search := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	ssdp := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	if _, err := conn.WriteTo([]byte(search), ssdp); err != nil {
+		return "", "", err
+	}
+	conn.SetDeadline(time.Now().Add(gatewayTimeout))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return "", "", fmt.Errorf("UPnP: no internet gateway device found")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+	if err != nil {
+		return "", "", fmt.Errorf("UPnP: %w", err)
+	}
+	location := resp.Header.Get("Location")
+	if len(location) == 0 {
+		return "", "", fmt.Errorf("UPnP: no device description location")
+	}
+
+	client := &http.Client{Timeout: gatewayTimeout}
+	descResp, err := client.Get(location)
+	if err != nil {
+		return "", "", fmt.Errorf("UPnP: %w", err)
+	}
+	defer descResp.Body.Close()
+	// the services are nested in devices and sub devices, so they are found by walking all elements
+	var service struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	}
+	decoder := xml.NewDecoder(io.LimitReader(descResp.Body, 1024*1024))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "service" {
+			continue
+		}
+		if err := decoder.DecodeElement(&service, &start); err != nil {
+			break
+		}
+		if strings.Contains(service.ServiceType, "WANIPConnection") || strings.Contains(service.ServiceType, "WANPPPConnection") {
+			base, _ := url.Parse(location)
+			control, err := base.Parse(service.ControlURL)
+			if err != nil {
+				return "", "", fmt.Errorf("UPnP: %w", err)
+			}
+			return control.String(), service.ServiceType, nil
+		}
+	}
+	return "", "", fmt.Errorf("UPnP: the gateway has no WAN connection service")
+}
+
+/*
+upnpExternalIP asks an internet gateway device for its external address with UPnP IGD
+
+Returns:
+
+	the external IPv4 address of the gateway
+*/
+func upnpExternalIP() (string, error) {
+	controlURL, serviceType, err := upnpControlURL()
+	if err != nil {
+		return "", err
+	}
+	body := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		`<u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequest(http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	client := &http.Client{Timeout: gatewayTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("UPnP: %w", err)
+	}
+	defer resp.Body.Close()
+	var envelope struct {
+		Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&envelope); err != nil {
+		return "", fmt.Errorf("UPnP: %w", err)
+	}
+	if net.ParseIP(envelope.Address) == nil {
+		return "", fmt.Errorf("UPnP: the gateway has no external address")
+	}
+	return envelope.Address, nil
+}
+
+/*
+gatewayExternalIP asks the local router for its external address, with NAT-PMP first and UPnP IGD second
+
+Returns:
+
+	the external IPv4 address of the router, and the method that found it
+*/
+func gatewayExternalIP() (string, string, error) {
+	var failures []string
+	if gateway, err := defaultGateway(); err != nil {
+		failures = append(failures, err.Error())
+	} else if ip, err := natpmpExternalIP(gateway); err != nil {
+		failures = append(failures, err.Error())
+	} else {
+		return ip, "NAT-PMP", nil
+	}
+	ip, err := upnpExternalIP()
+	if err != nil {
+		failures = append(failures, err.Error())
+		return "", "", fmt.Errorf("the gateway did not report its external address: %s", strings.Join(failures, "; "))
+	}
+	return ip, "UPnP", nil
+}
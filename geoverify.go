@@ -0,0 +1,143 @@
+/*
+
+geoverify.go
+
+Support for the -geo-verify option, which measures the round trip time to each IP address and
+flags results where the RTT is lower than the speed of light allows for the claimed distance.
+This usually means the geolocation data is wrong or the address is anycast.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// light travels through optical fiber at roughly two thirds of its speed in a vacuum
+const fiberMilesPerMs float64 = 186.282 * 2 / 3
+
+const rttTimeout = 3 * time.Second
+
+// The measured round trip time to an IP address
+type rttResult struct {
+	rtt time.Duration
+	err error
+}
+
+/*
+measureRTT times a TCP handshake with ip, which takes one round trip
+This is used instead of ICMP ping, which needs elevated privileges
+
+Args:
+
+	ip: the IP address to connect to
+
+Returns:
+
+	a rttResult struct with the fastest handshake time of ports 443 and 80
+*/
+func measureRTT(ip string) rttResult {
+	best := rttResult{err: fmt.Errorf("no response from %s", ip)}
+	for _, port := range []string{"443", "80"} {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), rttTimeout)
+		if err != nil {
+			continue
+		}
+		elapsed := time.Since(start)
+		conn.Close()
+		if best.err != nil || elapsed < best.rtt {
+			best = rttResult{rtt: elapsed}
+		}
+	}
+	return best
+}
+
+/*
+measureAllRTT concurrently calls measureRTT for each IP address
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	ipAddrs: a slice of IP addresses
+
+Returns:
+
+	a map where key=IP address, value=rttResult
+*/
+func measureAllRTT(workers int, ipAddrs []string) map[string]rttResult {
+	rtts := make(map[string]rttResult)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, workers)
+	for _, ip := range ipAddrs {
+		wg.Add(1)
+		limit <- true
+		go func(ip string) {
+			defer wg.Done()
+			result := measureRTT(ip)
+			mu.Lock()
+			rtts[ip] = result
+			mu.Unlock()
+			<-limit
+		}(ip)
+	}
+	wg.Wait()
+	return rtts
+}
+
+/*
+minimumRTT returns the fastest possible round trip time over the given distance
+
+Args:
+
+	miles: the one way distance
+
+Returns:
+
+	the round trip time at the speed of light in fiber
+*/
+func minimumRTT(miles float64) time.Duration {
+	return time.Duration(2 * miles / fiberMilesPerMs * float64(time.Millisecond))
+}
+
+/*
+verifyGeo flags results whose measured RTT is physically impossible for the distance to their location
+
+Args:
+
+	rtts: a map as returned by measureAllRTT
+
+	ipInfo: a slice of ipInfoResult structs for all resolved IP addresses
+
+	reverseIP: a map where key=IP address, value=hostname
+
+	loc: the local IP addresses location in this format: "lat, lon"
+
+Returns:
+
+	a slice of warning messages, one for each impossible RTT
+*/
+func verifyGeo(rtts map[string]rttResult, ipInfo []ipInfoResult, reverseIP map[string]string, loc string) []string {
+	var warnings []string
+	for _, info := range ipInfo {
+		result, ok := rtts[info.Ip]
+		if !ok || result.err != nil || !hasLocation(info.Loc) || !hasLocation(loc) {
+			continue
+		}
+		lat1, lon1 := latlon2coord(loc)
+		lat2, lon2 := latlon2coord(info.Loc)
+		miles := HaversineDistance(lat1, lon1, lat2, lon2)
+		if bound := minimumRTT(miles); result.rtt < bound {
+			warnings = append(warnings, fmt.Sprintf("%s (%s): RTT of %v is below the %v minimum for %.2f miles; the location or an anycast assumption is wrong", reverseIP[info.Ip], info.Ip, result.rtt.Round(time.Microsecond), bound.Round(time.Microsecond), miles))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
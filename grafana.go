@@ -0,0 +1,265 @@
+/*
+
+grafana.go
+
+A Grafana data source for the serve subcommand, implementing the Simple JSON / JSON API datasource
+contract under /grafana, so that the -history of past runs can be charted without an exporter:
+
+	GET  /grafana/             200, used by Grafana to test the data source
+	POST /grafana/search       the metric names
+	POST /grafana/query        time series or tables for the requested metrics and time range
+	POST /grafana/annotations  always empty
+
+The metrics are:
+
+	lookups                    the number of looked up IP addresses
+	distance_p50, _p90, _p99   percentiles of the distance in miles
+	country:<code>             the number of looked up IP addresses in a country
+	countries                  a table of the number of looked up IP addresses per country
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of a Grafana query request that are used
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+// A time series in a Grafana query response; each data point is [value, unix time in milliseconds]
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// A column of a table in a Grafana query response
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// A table in a Grafana query response
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+var grafanaPercentiles = map[string]float64{"distance_p50": 50, "distance_p90": 90, "distance_p99": 99}
+
+/*
+percentile returns a percentile of values with the nearest rank method
+
+Args:
+
+	values: the values, which are sorted in place
+
+	p: the percentile, from 0 to 100
+
+Returns:
+
+	the value at the percentile
+*/
+func percentile(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
+
+/*
+grafanaBuckets groups the history records in a time range into buckets of interval
+
+Args:
+
+	records: the history
+
+	from: the start of the range
+
+	to: the end of the range
+
+	interval: the bucket size; when zero, each run is its own bucket
+
+Returns:
+
+	the start times of the buckets in unix milliseconds, in order, and the records of each bucket
+*/
+func grafanaBuckets(records []historyRecord, from time.Time, to time.Time, interval time.Duration) ([]int64, map[int64][]historyRecord) {
+	buckets := make(map[int64][]historyRecord)
+	var times []int64
+	for _, record := range records {
+		if record.Time.Before(from) || record.Time.After(to) {
+			continue
+		}
+		bucket := record.Time
+		if interval > 0 {
+			bucket = record.Time.Truncate(interval)
+		}
+		ms := bucket.UnixMilli()
+		if _, ok := buckets[ms]; !ok {
+			times = append(times, ms)
+		}
+		buckets[ms] = append(buckets[ms], record)
+	}
+	sort.Slice(times, func(a, b int) bool { return times[a] < times[b] })
+	return times, buckets
+}
+
+/*
+grafanaMetrics returns the names of all metrics for the history
+
+Args:
+
+	records: the history
+
+Returns:
+
+	the metric names
+*/
+func grafanaMetrics(records []historyRecord) []string {
+	metrics := []string{"lookups", "distance_p50", "distance_p90", "distance_p99", "countries"}
+	var countries []string
+	for _, record := range records {
+		if !stringInSlice(record.Country, countries) {
+			countries = append(countries, record.Country)
+		}
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		metrics = append(metrics, "country:"+country)
+	}
+	return metrics
+}
+
+/*
+grafanaResult computes a single metric for a Grafana query
+
+Args:
+
+	target: the metric name
+
+	times: the bucket start times, as returned by grafanaBuckets
+
+	buckets: the records of each bucket
+
+Returns:
+
+	a grafanaSeries or grafanaTable, and false for an unknown metric
+*/
+func grafanaResult(target string, times []int64, buckets map[int64][]historyRecord) (interface{}, bool) {
+	if target == "countries" {
+		counts := make(map[string]int)
+		for _, records := range buckets {
+			for _, record := range records {
+				counts[record.Country]++
+			}
+		}
+		table := grafanaTable{Type: "table", Columns: []grafanaColumn{{"Country", "string"}, {"Lookups", "number"}}, Rows: [][]interface{}{}}
+		for country, count := range counts {
+			table.Rows = append(table.Rows, []interface{}{country, count})
+		}
+		sort.Slice(table.Rows, func(a, b int) bool { return table.Rows[a][1].(int) > table.Rows[b][1].(int) })
+		return table, true
+	}
+
+	p, isPercentile := grafanaPercentiles[target]
+	country, isCountry := strings.CutPrefix(target, "country:")
+	if target != "lookups" && !isPercentile && !isCountry {
+		return nil, false
+	}
+	series := grafanaSeries{Target: target, Datapoints: [][2]float64{}}
+	for _, ms := range times {
+		var value float64
+		switch {
+		case isPercentile:
+			var distances []float64
+			for _, record := range buckets[ms] {
+				if record.Distance != nil {
+					distances = append(distances, *record.Distance)
+				}
+			}
+			if len(distances) == 0 {
+				continue
+			}
+			value = percentile(distances, p)
+		case isCountry:
+			for _, record := range buckets[ms] {
+				if record.Country == country {
+					value++
+				}
+			}
+		default:
+			value = float64(len(buckets[ms]))
+		}
+		series.Datapoints = append(series.Datapoints, [2]float64{value, float64(ms)})
+	}
+	return series, true
+}
+
+// handleGrafana implements /grafana/; name is the team of the API key, or empty when keys are not required
+func (srv *server) handleGrafana(w http.ResponseWriter, r *http.Request, name string) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/grafana")
+	if endpoint == "/" || endpoint == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	records, err := srv.history.load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch endpoint {
+	case "/search":
+		writeJSON(w, grafanaMetrics(records))
+	case "/annotations":
+		writeJSON(w, []interface{}{})
+	case "/query":
+		var query grafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query.Range.To.IsZero() {
+			query.Range.To = time.Now()
+		}
+		times, buckets := grafanaBuckets(records, query.Range.From, query.Range.To, time.Duration(query.IntervalMs)*time.Millisecond)
+		results := []interface{}{}
+		for _, target := range query.Targets {
+			result, ok := grafanaResult(target.Target, times, buckets)
+			if !ok {
+				http.Error(w, "unknown metric: "+strconv.Quote(target.Target), http.StatusBadRequest)
+				return
+			}
+			results = append(results, result)
+		}
+		writeJSON(w, results)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+
+groupby.go
+
+Support for -group-by, which collapses the results into one row per org, country or AS number,
+with the number of IP addresses and inputs in each group and a few of its inputs as examples.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var groupByKeys = []string{"org", "country", "asn"}
+
+// The number of inputs shown as examples of each group
+const groupExamples = 3
+
+// The results that share the same org, country or AS number
+type rowGroup struct {
+	Group     string   `json:"group"`
+	Addresses int      `json:"addresses"`
+	Inputs    []string `json:"inputs"`
+}
+
+/*
+groupRows collapses rows into groups
+
+Args:
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	key: one of groupByKeys
+
+Returns:
+
+	a slice of rowGroup, largest group first; rows of failed lookups are grouped as "N/A"
+*/
+func groupRows(rows []resultRow, key string) []rowGroup {
+	var order []string
+	groups := make(map[string]*rowGroup)
+	addresses := make(map[string][]string)
+	for _, row := range rows {
+		var name string
+		switch key {
+		case "org":
+			name = row.Org
+		case "country":
+			name = row.Country
+		case "asn":
+			name = asNumber(row.Org)
+		}
+		name = orNA(name)
+		group, ok := groups[name]
+		if !ok {
+			group = &rowGroup{Group: name}
+			groups[name] = group
+			order = append(order, name)
+		}
+		if !stringInSlice(row.Input, group.Inputs) {
+			group.Inputs = append(group.Inputs, row.Input)
+		}
+		if !stringInSlice(row.Ip, addresses[name]) {
+			addresses[name] = append(addresses[name], row.Ip)
+			group.Addresses++
+		}
+	}
+
+	var result []rowGroup
+	for _, name := range order {
+		result = append(result, *groups[name])
+	}
+	sort.SliceStable(result, func(a, b int) bool {
+		if result[a].Addresses != result[b].Addresses {
+			return result[a].Addresses > result[b].Addresses
+		}
+		return result[a].Group < result[b].Group
+	})
+	return result
+}
+
+/*
+outputGroups outputs one row per group
+
+Args:
+
+	w: where to write the output to
+
+	groups: a slice as returned by groupRows
+
+	key: the key the rows were grouped by, used as the first column name
+
+	format: table, json or tsv
+
+	wrap: wrap output to better fit the screen width
+
+Returns:
+
+	an error if the output could not be written
+*/
+func outputGroups(w io.Writer, groups []rowGroup, key string, format string, wrap bool) error {
+	if format == "json" {
+		if groups == nil {
+			groups = []rowGroup{}
+		}
+		return writeJSON(w, groups)
+	}
+
+	var allRows [][]string
+	for _, group := range groups {
+		examples := group.Inputs
+		if len(examples) > groupExamples {
+			examples = append(examples[:groupExamples:groupExamples], fmt.Sprintf("+%d more", len(group.Inputs)-groupExamples))
+		}
+		allRows = append(allRows, []string{group.Group, strconv.Itoa(group.Addresses), strconv.Itoa(len(group.Inputs)), strings.Join(examples, ", ")})
+	}
+	return outputSummaryCells(w, []string{strings.ToUpper(key[:1]) + key[1:], "Addresses", "Inputs", "Examples"}, allRows, format, wrap)
+}
@@ -0,0 +1,153 @@
+/*
+
+groups.go
+
+Country groups used to tag results for compliance triage, such as with: -tags gdpr,ofac,fiveeyes
+Results located in the sanctioned group are always flagged, and -fail-on sets the exit status when a
+result is located in any of the given groups, for compliance checks in CI pipelines.
+Additional groups can be defined in a file that uses the same format as the aliases file:
+
+	# name: ISO 3166 country codes separated by commas and/or spaces
+	vendors: US, CA, IE
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var euCountries = []string{"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK"}
+
+// builtinCountryGroups maps a group name to the ISO 3166 country codes of its members
+var builtinCountryGroups = map[string][]string{
+	// European Union
+	"eu": euCountries,
+	// European Economic Area, where the GDPR applies
+	"gdpr": append([]string{"IS", "LI", "NO"}, euCountries...),
+	// countries under comprehensive OFAC sanctions programs
+	"ofac": {"CU", "IR", "KP", "SY"},
+	// members of the Five Eyes intelligence alliance
+	"fiveeyes": {"AU", "CA", "GB", "NZ", "US"},
+	// jurisdictions under comprehensive or broad US, EU and UK sanctions and export controls;
+	// results located there are always flagged, and the group can be redefined with -tag-groups
+	"sanctioned": {"BY", "CU", "IR", "KP", "RU", "SY"},
+}
+
+/*
+loadCountryGroups returns the built-in country groups merged with those defined in fname
+
+Args:
+
+	fname: a file defining additional groups; when empty, only the built-in groups are returned
+
+Returns:
+
+	a map where key=group name, value=a slice of upper case country codes
+*/
+func loadCountryGroups(fname string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for name, countries := range builtinCountryGroups {
+		groups[name] = countries
+	}
+	if len(fname) == 0 {
+		return groups, nil
+	}
+	custom, err := loadAliases(fname)
+	if err != nil {
+		return nil, err
+	}
+	for name, countries := range custom {
+		for i := range countries {
+			countries[i] = strings.ToUpper(countries[i])
+		}
+		groups[strings.ToLower(name)] = countries
+	}
+	return groups, nil
+}
+
+/*
+selectCountryGroups returns only the groups named in list
+
+Args:
+
+	list: a comma separated list of group names, such as the value given to -tags
+
+	groups: a map as returned by loadCountryGroups
+
+Returns:
+
+	a map where key=group name, value=a slice of country codes
+*/
+func selectCountryGroups(list string, groups map[string][]string) (map[string][]string, error) {
+	selected := make(map[string][]string)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		countries, ok := groups[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown country group: %s", name)
+		}
+		selected[name] = countries
+	}
+	return selected, nil
+}
+
+/*
+countryTags returns the names of all groups that country belongs to
+
+Args:
+
+	country: an ISO 3166 country code
+
+	groups: a map where key=group name, value=a slice of country codes
+
+Returns:
+
+	a sorted slice of group names
+*/
+func countryTags(country string, groups map[string][]string) []string {
+	var tags []string
+	for name, countries := range groups {
+		if stringInSlice(strings.ToUpper(country), countries) {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+/*
+groupWarnings describes the rows located in any of the given country groups
+
+Args:
+
+	rows: a slice of resultRow structs
+
+	groups: a map where key=group name, value=a slice of country codes
+
+Returns:
+
+	a warning for each row located in a group, such as:
+	example.com (192.0.2.1) is located in IR, which is in the sanctioned group
+*/
+func groupWarnings(rows []resultRow, groups map[string][]string) []string {
+	var warnings []string
+	for _, row := range rows {
+		tags := countryTags(row.Country, groups)
+		switch len(tags) {
+		case 0:
+			continue
+		case 1:
+			warnings = append(warnings, fmt.Sprintf("%s (%s) is located in %s, which is in the %s group", row.Input, row.Ip, strings.ToUpper(row.Country), tags[0]))
+		default:
+			warnings = append(warnings, fmt.Sprintf("%s (%s) is located in %s, which is in the %s groups", row.Input, row.Ip, strings.ToUpper(row.Country), strings.Join(tags, ", ")))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,121 @@
+/*
+
+har.go
+
+Support for the -har option, which reads a HAR file exported from browser devtools, looks up every
+contacted host and outputs a breakdown of the page load's requests by country and org.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+readHAR extracts the host of every request in a HAR file
+
+Args:
+
+	fname: the HAR file name
+
+Returns:
+
+	a slice of distinct host names, in the order they were first contacted
+
+	a map where key=host name, value=number of requests
+*/
+func readHAR(fname string) ([]string, map[string]int, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	var har struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					URL string `json:"url"`
+				} `json:"request"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, nil, err
+	}
+
+	var hosts []string
+	requests := make(map[string]int)
+	for _, entry := range har.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil || len(u.Hostname()) == 0 {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if requests[host] == 0 {
+			hosts = append(hosts, host)
+		}
+		requests[host]++
+	}
+	return hosts, requests, nil
+}
+
+/*
+outputHARBreakdown outputs the number of hosts and requests per country and org, most requests first
+
+Args:
+
+	w: where to write the table to
+
+	rows: the rows of the current run
+
+	requests: a map as returned by readHAR
+*/
+func outputHARBreakdown(w io.Writer, rows []resultRow, requests map[string]int) {
+	type group struct {
+		hosts    []string
+		requests int
+	}
+	groups := make(map[[2]string]*group)
+	for _, row := range rows {
+		key := [2]string{orNA(row.Country), orNA(row.Org)}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{}
+			groups[key] = g
+		}
+		if !stringInSlice(row.Input, g.hosts) {
+			g.hosts = append(g.hosts, row.Input)
+			g.requests += requests[row.Input]
+		}
+	}
+
+	var keys [][2]string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if groups[keys[a]].requests != groups[keys[b]].requests {
+			return groups[keys[a]].requests > groups[keys[b]].requests
+		}
+		return keys[a][0]+keys[a][1] < keys[b][0]+keys[b][1]
+	})
+
+	var allRows [][]string
+	for _, key := range keys {
+		g := groups[key]
+		sort.Strings(g.hosts)
+		allRows = append(allRows, []string{key[0], key[1], strconv.Itoa(g.requests), strings.Join(g.hosts, " ")})
+	}
+	table := newTable(w)
+	table.SetHeader([]string{"Country", "Org", "Requests", "Hosts"})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(allRows)
+	table.Render()
+}
@@ -0,0 +1,175 @@
+/*
+
+hijack.go
+
+Heuristics that flag results suggesting a DNS hijack, which are output as warnings after every lookup:
+
+	- a bank-like domain resolving into residential ISP space, where a bank would not host its services
+	- with -history, an input whose ASN changed to one in a different country than in its previous run
+
+Residential ISP space is recognized by the ASN type given by paid ipinfo.io plans, or when that is not
+available, by the ASNs of large residential ISPs.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The AS details given by paid ipinfo.io plans
+type ipInfoASN struct {
+	ASN    string `json:"asn"`
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+	Type   string `json:"type"` // isp, hosting, business, education or government
+}
+
+// Words that make a domain look like a bank when a label contains them
+var bankWords = []string{"bank", "banco", "banque", "sparkasse", "creditunion", "bausparkasse"}
+
+// Labels of well known banks and payment providers, which are matched exactly so that chase does not match purchase
+var bankLabels = []string{"amex", "americanexpress", "barclays", "bnpparibas", "capitalone", "chase", "citi", "citibank",
+	"hsbc", "ing", "lloyds", "natwest", "paypal", "rbc", "revolut", "santander", "schwab", "ubs", "venmo", "wellsfargo"}
+
+// ASNs of large residential ISPs, used when ipinfo.io does not give the ASN type
+var residentialASNs = []string{
+	"AS7922",  // Comcast
+	"AS7018",  // AT&T
+	"AS701",   // Verizon
+	"AS20115", // Charter
+	"AS11427", // Charter
+	"AS22773", // Cox
+	"AS5650",  // Frontier
+	"AS209",   // CenturyLink
+	"AS812",   // Rogers
+	"AS577",   // Bell Canada
+	"AS6327",  // Shaw
+	"AS3320",  // Deutsche Telekom
+	"AS3209",  // Vodafone Germany
+	"AS3215",  // Orange
+	"AS12322", // Free
+	"AS2856",  // BT
+	"AS5089",  // Virgin Media
+	"AS5607",  // Sky UK
+	"AS3352",  // Telefonica Spain
+	"AS3269",  // Telecom Italia
+	"AS1136",  // KPN
+	"AS6830",  // Liberty Global
+	"AS1221",  // Telstra
+	"AS4134",  // Chinanet
+	"AS4837",  // China Unicom
+	"AS55836", // Reliance Jio
+	"AS4766",  // Korea Telecom
+	"AS4713",  // NTT OCN
+	"AS28573", // Claro Brazil
+	"AS8151",  // Telmex
+}
+
+/*
+bankLike reports if a host name looks like the domain of a bank
+
+Args:
+
+	hostname: a host name
+
+Returns:
+
+	true when a label other than the top level domain contains a bank word or is a well known bank
+*/
+func bankLike(hostname string) bool {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(hostname, ".")), ".")
+	if len(labels) < 2 || net.ParseIP(hostname) != nil {
+		return false
+	}
+	for _, label := range labels[:len(labels)-1] {
+		if stringInSlice(label, bankLabels) {
+			return true
+		}
+		for _, word := range bankWords {
+			if strings.Contains(label, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+residentialNetwork reports if an IP address is in residential ISP space
+
+Args:
+
+	info: the IP info of the address
+
+Returns:
+
+	true when its ASN type is isp, or when no type is known and its ASN belongs to a large residential ISP
+*/
+func residentialNetwork(info ipInfoResult) bool {
+	if info.ASN != nil && len(info.ASN.Type) > 0 {
+		return info.ASN.Type == "isp"
+	}
+	return stringInSlice(asNumber(info.Org), residentialASNs)
+}
+
+/*
+hijackWarnings returns a warning for each result that suggests a DNS hijack
+
+Args:
+
+	rows: the rows of the current run
+
+	records: the history loaded before the current run was added, or nil without -history
+
+Returns:
+
+	a slice of warning messages, sorted
+*/
+func hijackWarnings(rows []resultRow, records []historyRecord) []string {
+	var warnings []string
+	for _, row := range rows {
+		if lookupSucceeded(row) && bankLike(row.Input) && residentialNetwork(row.ipInfoResult) {
+			warnings = append(warnings, fmt.Sprintf("possible DNS hijack: %s looks like a bank but resolves to %s in residential ISP space (%s)", row.Input, row.Ip, row.Org))
+		}
+	}
+
+	// the ASNs and their countries in the most recent run of each input
+	latest := make(map[string]time.Time)
+	asns := make(map[string]map[string]bool)
+	countries := make(map[string]map[string]bool)
+	for _, record := range records {
+		if record.Time.Before(latest[record.Input]) {
+			continue
+		}
+		if record.Time.After(latest[record.Input]) {
+			latest[record.Input] = record.Time
+			asns[record.Input] = make(map[string]bool)
+			countries[record.Input] = make(map[string]bool)
+		}
+		asns[record.Input][asNumber(record.Org)] = true
+		countries[record.Input][record.Country] = true
+	}
+	for _, row := range rows {
+		asn := asNumber(row.Org)
+		if !lookupSucceeded(row) || len(asn) == 0 || asns[row.Input] == nil {
+			continue
+		}
+		if !asns[row.Input][asn] && !countries[row.Input][row.Country] {
+			var before []string
+			for country := range countries[row.Input] {
+				before = append(before, country)
+			}
+			sort.Strings(before)
+			warnings = append(warnings, fmt.Sprintf("possible DNS hijack: %s resolves to %s in %s (%s), but was in %s on %s", row.Input, row.Ip, row.Country, asn,
+				strings.Join(before, ", "), latest[row.Input].Format("2006-01-02 15:04")))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,252 @@
+/*
+
+histimport.go
+
+The history import subcommand, which loads results generated by earlier versions of ipinfo or by other
+tools into the history given with -history, so that timeline and report cover pre-existing data:
+
+	ipinfo -history results.jsonl history import old-results.json
+
+Files can be JSON arrays, such as the output of -format json, JSON lines, such as the output of -format
+ndjson or another history file, or CSV and TSV files with a header, such as the output of -format tsv.
+Results without a time are recorded at the time given with -time, or at the modification time of the file.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the time formats accepted in the time column of CSV and TSV files
+var importTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+/*
+parseImportTime parses a time of an imported result
+
+Args:
+
+	value: such as 2024-05-31T12:00:00Z, 2024-05-31 12:00 or 2024-05-31
+
+Returns:
+
+	the time; times without a zone are in the local time zone
+*/
+func parseImportTime(value string) (time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s; use such as 2024-05-31T12:00:00Z or 2024-05-31 12:00", value)
+}
+
+/*
+readImportCSV reads results from a CSV or TSV file with a header; the columns are named as in the table
+or JSON output, such as Input, IP, Org, Country and Distance, and N/A is read as an empty value
+
+Args:
+
+	data: the content of the file
+
+Returns:
+
+	a slice of historyRecord structs; Time is zero unless the file has a time column
+*/
+func readImportCSV(data []byte) ([]historyRecord, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Contains(firstLine, []byte("\t")) {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range lines[0] {
+		columns[strings.NewReplacer(" ", "", "_", "").Replace(strings.ToLower(strings.TrimSpace(name)))] = i
+	}
+	if _, ok := columns["ip"]; !ok {
+		return nil, fmt.Errorf("no IP column in the header")
+	}
+
+	var records []historyRecord
+	for n, line := range lines[1:] {
+		cell := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(line) {
+				return ""
+			}
+			value := strings.TrimSpace(line[i])
+			if value == "N/A" {
+				return ""
+			}
+			return value
+		}
+		var record historyRecord
+		record.Input, record.Ip, record.Hostname, record.Org = cell("input"), cell("ip"), cell("hostname"), cell("org")
+		record.City, record.Region, record.Country, record.Loc, record.Postal = cell("city"), cell("region"), cell("country"), cell("loc"), cell("postal")
+		if distance, err := strconv.ParseFloat(strings.ReplaceAll(cell("distance"), ",", ""), 64); err == nil {
+			record.Distance = &distance
+		}
+		if value := cell("time"); len(value) > 0 {
+			if record.Time, err = parseImportTime(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+2, err)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+/*
+readImport reads the results of a file to import
+
+Args:
+
+	fname: a JSON, JSON lines, CSV or TSV file
+
+	defaultTime: the time of results that have none
+
+Returns:
+
+	a slice of historyRecord structs, with Input set to the IP address when the file has no input
+*/
+func readImport(fname string, defaultTime time.Time) ([]historyRecord, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var records []historyRecord
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(trimmed, &records)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		for decoder.More() {
+			var record historyRecord
+			if err = decoder.Decode(&record); err != nil {
+				break
+			}
+			records = append(records, record)
+		}
+	default:
+		records, err = readImportCSV(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	for i := range records {
+		if records[i].Time.IsZero() {
+			records[i].Time = defaultTime
+		}
+		if len(records[i].Input) == 0 {
+			records[i].Input = records[i].Ip
+		}
+	}
+	return records, nil
+}
+
+/*
+runHistory implements the history subcommand, which currently has the import command
+
+	ipinfo -history results.jsonl history import [-time 2024-05-31] <file...>
+
+Args:
+
+	args: the command line arguments following "history"
+
+	fname: the history to import into
+
+Returns:
+
+	an error when a file could not be read or the history could not be written
+*/
+func runHistory(args []string, fname string) error {
+	if len(args) == 0 || args[0] != "import" {
+		return fmt.Errorf("usage: ipinfo -history <file> history import [-time <time>] <file...>")
+	}
+	flags := flag.NewFlagSet("history import", flag.ContinueOnError)
+	timeFlag := flags.String("time", "", "the time of results that have none, such as 2024-05-31 12:00; default: the modification time of each file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if len(fname) == 0 {
+		return fmt.Errorf("history import needs the history given with -history")
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no files to import given")
+	}
+	var importTime time.Time
+	if len(*timeFlag) > 0 {
+		var err error
+		if importTime, err = parseImportTime(*timeFlag); err != nil {
+			return err
+		}
+	}
+
+	history, err := openHistory(fname)
+	if err != nil {
+		return err
+	}
+	existing, err := history.load()
+	if err != nil {
+		return err
+	}
+	// results that are already in the history, so that importing a file twice does not duplicate them
+	seen := make(map[string]bool)
+	key := func(record historyRecord) string {
+		return record.Time.UTC().Format(time.RFC3339Nano) + " " + record.Input + " " + record.Ip
+	}
+	for _, record := range existing {
+		seen[key(record)] = true
+	}
+
+	var added []historyRecord
+	skipped := 0
+	for _, name := range flags.Args() {
+		defaultTime := importTime
+		if defaultTime.IsZero() {
+			stat, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			defaultTime = stat.ModTime()
+		}
+		records, err := readImport(name, defaultTime)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if !lookupSucceeded(record.resultRow) || seen[key(record)] {
+				skipped++
+				continue
+			}
+			seen[key(record)] = true
+			added = append(added, record)
+		}
+	}
+	if len(added) > 0 {
+		if err := history.append(added); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d results imported, %d skipped as failed lookups or duplicates\n", len(added), skipped)
+	return nil
+}
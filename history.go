@@ -0,0 +1,288 @@
+/*
+
+history.go
+
+A history of results, kept with -history as a JSON lines file, or in any storage backend supported by
+openHistory, with one record per row and run.
+Each run is compared with the previous run of the same inputs, so that changes are reported.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A single row of a past run
+type historyRecord struct {
+	Time time.Time `json:"time"`
+	resultRow
+}
+
+// A history kept as a JSON lines file
+type fileHistory struct {
+	fname string
+}
+
+/*
+load reads all records from the history file; a missing file is not an error
+
+Returns:
+
+	a slice of historyRecord structs in the order they were written
+*/
+func (h fileHistory) load() ([]historyRecord, error) {
+	file, err := os.Open(h.fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		var record historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", h.fname, lineNum, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+/*
+append adds records to the end of the history file
+
+Args:
+
+	records: the records to add
+*/
+func (h fileHistory) append(records []historyRecord) error {
+	file, err := os.OpenFile(h.fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+lookupSucceeded checks if ipinfo.io returned data for a row; failed lookups are not recorded
+
+Args:
+
+	row: a resultRow struct
+
+Returns:
+
+	true when the row has a country
+*/
+func lookupSucceeded(row resultRow) bool {
+	return len(row.Country) > 0
+}
+
+/*
+appendHistory adds the rows of the current run to a history
+
+Args:
+
+	history: the history, as returned by openHistory
+
+	rows: the rows of the current run; rows of failed lookups are skipped
+
+	now: the time of the current run
+*/
+func appendHistory(history historyStore, rows []resultRow, now time.Time) error {
+	var records []historyRecord
+	for _, row := range rows {
+		if lookupSucceeded(row) {
+			records = append(records, historyRecord{Time: now, resultRow: row})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return history.append(records)
+}
+
+/*
+distinctValues returns the sorted, distinct values of a field for the given rows
+
+Args:
+
+	rows: a slice of resultRow structs
+
+	field: returns the value to collect from a row
+
+Returns:
+
+	the values joined with ", "
+*/
+func distinctValues(rows []resultRow, field func(resultRow) string) string {
+	var values []string
+	for _, row := range rows {
+		if value := field(row); !stringInSlice(value, values) {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+/*
+compareHistory reports inputs whose org or location differ from their most recent run in history
+
+Args:
+
+	records: the history, as returned by historyStore.load
+
+	rows: the rows of the current run
+
+Returns:
+
+	a slice of warning messages, one for each change
+*/
+func compareHistory(records []historyRecord, rows []resultRow) []string {
+	// the rows of the most recent run of each input
+	previous := make(map[string][]resultRow)
+	latest := make(map[string]time.Time)
+	for _, record := range records {
+		switch {
+		case record.Time.After(latest[record.Input]):
+			latest[record.Input] = record.Time
+			previous[record.Input] = []resultRow{record.resultRow}
+		case record.Time.Equal(latest[record.Input]):
+			previous[record.Input] = append(previous[record.Input], record.resultRow)
+		}
+	}
+	current := make(map[string][]resultRow)
+	for _, row := range rows {
+		if lookupSucceeded(row) {
+			current[row.Input] = append(current[row.Input], row)
+		}
+	}
+
+	org := func(row resultRow) string { return row.Org }
+	location := func(row resultRow) string { return row.City + ", " + row.Region + ", " + row.Country }
+	var warnings []string
+	for input, now := range current {
+		before, ok := previous[input]
+		if !ok {
+			continue
+		}
+		since := latest[input].Format("2006-01-02 15:04")
+		if a, b := distinctValues(before, org), distinctValues(now, org); a != b {
+			warnings = append(warnings, fmt.Sprintf("%s: org changed since %s from %s to %s", input, since, a, b))
+		}
+		if a, b := distinctValues(before, location), distinctValues(now, location); a != b {
+			warnings = append(warnings, fmt.Sprintf("%s: location changed since %s from %s to %s", input, since, a, b))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+/*
+runTimeline outputs how the recorded org and location of IP addresses changed over time
+
+	ipinfo -history results.jsonl timeline <ip or hostname...>
+
+Args:
+
+	args: the command line arguments following "timeline"; each is matched against the IP address and the input of the history records
+
+	fname: the history file name
+
+	wrap: wrap output to better fit the screen width
+*/
+func runTimeline(args []string, fname string, wrap bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ipinfo -history <file> timeline <ip or hostname...>")
+	}
+	if len(fname) == 0 {
+		return fmt.Errorf("timeline needs the history file given with -history")
+	}
+	history, err := openHistory(fname)
+	if err != nil {
+		return err
+	}
+	records, err := history.load()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(records, func(a, b int) bool {
+		return records[a].Time.Before(records[b].Time)
+	})
+
+	// consecutive records of an IP address with the same org and location are combined into a single span
+	type span struct {
+		first, last time.Time
+		runs        int
+		row         resultRow
+	}
+	var order []string
+	spans := make(map[string][]*span)
+	for _, record := range records {
+		if !stringInSlice(record.Ip, args) && !stringInSlice(record.Input, args) {
+			continue
+		}
+		ipSpans := spans[record.Ip]
+		if len(ipSpans) == 0 {
+			order = append(order, record.Ip)
+		}
+		if n := len(ipSpans); n > 0 {
+			last := ipSpans[n-1]
+			if last.row.Org == record.Org && last.row.City == record.City && last.row.Region == record.Region && last.row.Country == record.Country && last.row.Loc == record.Loc {
+				if !last.last.Equal(record.Time) {
+					last.runs++
+				}
+				last.last = record.Time
+				continue
+			}
+		}
+		spans[record.Ip] = append(ipSpans, &span{first: record.Time, last: record.Time, runs: 1, row: record.resultRow})
+	}
+	if len(order) == 0 {
+		return fmt.Errorf("no history found for: %s", strings.Join(args, ", "))
+	}
+
+	const layout = "2006-01-02 15:04"
+	var allRows [][]string
+	for _, ip := range order {
+		for _, s := range spans[ip] {
+			r := s.row
+			allRows = append(allRows, []string{ip, s.first.Format(layout), s.last.Format(layout), strconv.Itoa(s.runs), r.Org, r.City, r.Region, r.Country, r.Loc})
+		}
+	}
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"IP", "First Seen", "Last Seen", "Runs", "Org", "City", "Region", "Country", "Loc"})
+	table.SetAutoWrapText(wrap)
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
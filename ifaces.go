@@ -0,0 +1,200 @@
+/*
+
+ifaces.go
+
+The ifaces subcommand, which lists the addresses of the local network interfaces, classifies each one
+and geolocates the public ones, for a quick look at what this machine looks like from the outside.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the IFA_F_TEMPORARY flag of /proc/net/if_inet6, set on IPv6 privacy addresses (RFC 8981)
+const ifaTemporary = 0x01
+
+// An address of a local interface
+type ifaceAddress struct {
+	Interface string        `json:"interface"`
+	Up        bool          `json:"up"`
+	Address   string        `json:"address"`
+	Prefix    int           `json:"prefix"`
+	Class     string        `json:"class"`
+	Info      *ipInfoResult `json:"info,omitempty"` // only for public addresses
+}
+
+/*
+temporaryIPv6 reads the IPv6 privacy addresses from /proc/net/if_inet6; other systems do not expose them
+
+Returns:
+
+	a map where key=IPv6 address, value=true for temporary addresses
+*/
+func temporaryIPv6() map[string]bool {
+	temporary := make(map[string]bool)
+	file, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return temporary
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// address, index, prefix length, scope, flags and name, all but the name in hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		flags, flagsErr := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil || flagsErr != nil || len(raw) != net.IPv6len {
+			continue
+		}
+		if flags&ifaTemporary != 0 {
+			temporary[net.IP(raw).String()] = true
+		}
+	}
+	return temporary
+}
+
+/*
+classifyAddress describes the kind of an interface address
+
+Args:
+
+	ip: the address
+
+	temporary: the address is an IPv6 privacy address
+
+Returns:
+
+	one of: loopback, link-local, RFC1918, CGNAT, ULA, v6 temporary or public
+*/
+func classifyAddress(ip net.IP, temporary bool) string {
+	switch {
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case cgnatNetwork.Contains(ip):
+		return "CGNAT"
+	case ip.IsPrivate() && ip.To4() != nil:
+		return "RFC1918"
+	case ip.IsPrivate():
+		return "ULA"
+	case temporary:
+		return "v6 temporary"
+	}
+	return "public"
+}
+
+/*
+localAddresses lists and classifies the addresses of all local interfaces
+
+Returns:
+
+	a slice of ifaceAddress structs, in interface order
+*/
+func localAddresses() ([]ifaceAddress, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	temporary := temporaryIPv6()
+	var found []ifaceAddress
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			prefix, _ := ipNet.Mask.Size()
+			found = append(found, ifaceAddress{
+				Interface: iface.Name,
+				Up:        iface.Flags&net.FlagUp != 0,
+				Address:   ipNet.IP.String(),
+				Prefix:    prefix,
+				Class:     classifyAddress(ipNet.IP, temporary[ipNet.IP.String()]),
+			})
+		}
+	}
+	return found, nil
+}
+
+/*
+runIfaces implements the ifaces subcommand
+
+Args:
+
+	args: the command line arguments after ifaces
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an error if the interfaces could not be read
+*/
+func runIfaces(args []string, workers int, cache ipCache) error {
+	flags := flag.NewFlagSet("ifaces", flag.ContinueOnError)
+	jsonFlag := flags.Bool("json", false, "output JSON instead of a table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	addresses, err := localAddresses()
+	if err != nil {
+		return err
+	}
+
+	var public []string
+	for _, addr := range addresses {
+		if (addr.Class == "public" || addr.Class == "v6 temporary") && !stringInSlice(addr.Address, public) {
+			public = append(public, addr.Address)
+		}
+	}
+	infos := make(map[string]ipInfoResult)
+	for _, info := range resolveAllIpInfo(workers, public, cache) {
+		infos[info.Ip] = info
+	}
+	for i := range addresses {
+		if info, ok := infos[addresses[i].Address]; ok {
+			addresses[i].Info = &info
+		}
+	}
+
+	if *jsonFlag {
+		return writeJSON(os.Stdout, addresses)
+	}
+	var allRows [][]string
+	for _, addr := range addresses {
+		state := "down"
+		if addr.Up {
+			state = "up"
+		}
+		row := []string{addr.Interface, state, addr.Address + "/" + strconv.Itoa(addr.Prefix), addr.Class, "", "", ""}
+		if addr.Info != nil {
+			row[4], row[5], row[6] = orNA(addr.Info.Org), orNA(addr.Info.City), orNA(addr.Info.Country)
+		}
+		allRows = append(allRows, row)
+	}
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"Interface", "State", "Address", "Class", "Org", "City", "Country"})
+	table.SetAutoWrapText(false)
+	table.SetAutoMergeCellsByColumnIndex([]int{0})
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
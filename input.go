@@ -0,0 +1,286 @@
+/*
+
+input.go
+
+Functions that read targets from sources other than the command line
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+/*
+readTargetsFile reads one target per line; blank lines and lines starting with # are ignored
+
+Args:
+
+	fname: the file name, or - for standard input
+
+Returns:
+
+	a slice of targets
+*/
+func readTargetsFile(fname string) ([]string, error) {
+	if fname == "-" {
+		return readTargets(os.Stdin)
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readTargets(file)
+}
+
+/*
+readTargets is the same as readTargetsFile for an already open file or stream
+*/
+func readTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
+}
+
+/*
+expandStdin replaces a - argument with the targets read from standard input, one per line
+
+Args:
+
+	args: the command line targets
+
+Returns:
+
+	args with - replaced; standard input is only read once
+*/
+func expandStdin(args []string) ([]string, error) {
+	var expanded []string
+	read := false
+	for _, arg := range args {
+		if arg != "-" {
+			expanded = append(expanded, arg)
+			continue
+		}
+		if read {
+			continue
+		}
+		fromStdin, err := readTargets(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, fromStdin...)
+		read = true
+	}
+	return expanded, nil
+}
+
+/*
+stdinIsPiped reports if standard input is a pipe or a redirected file, rather than a terminal or /dev/null
+
+Returns:
+
+	true when targets can be read from standard input
+*/
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
+
+/*
+readSSHConfig extracts the hosts from an OpenSSH client configuration file
+HostName values are used; a Host pattern without a HostName is used when it has no wildcards
+
+Args:
+
+	fname: the file name, such as ~/.ssh/config
+
+Returns:
+
+	a slice of targets
+*/
+func readSSHConfig(fname string) ([]string, error) {
+	lines, err := readTargetsFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	var pending []string // Host patterns of the current block, used when it has no HostName
+	flush := func() {
+		for _, host := range pending {
+			if !strings.ContainsAny(host, "*?!") && !stringInSlice(host, targets) {
+				targets = append(targets, host)
+			}
+		}
+		pending = nil
+	}
+	for _, line := range lines {
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "host", "match":
+			flush()
+			if strings.ToLower(fields[0]) == "host" {
+				pending = fields[1:]
+			}
+		case "hostname":
+			pending = nil
+			if !strings.Contains(fields[1], "%") && !stringInSlice(fields[1], targets) {
+				targets = append(targets, fields[1])
+			}
+		}
+	}
+	flush()
+	return targets, nil
+}
+
+/*
+readKnownHosts extracts the hosts from an OpenSSH known_hosts file
+Hashed entries, wildcard patterns and revoked keys are skipped, and [host]:port is reduced to host
+
+Args:
+
+	fname: the file name, such as ~/.ssh/known_hosts
+
+Returns:
+
+	a slice of targets
+*/
+func readKnownHosts(fname string) ([]string, error) {
+	lines, err := readTargetsFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+			if fields[0] == "@revoked" {
+				continue
+			}
+			fields = fields[1:] // @cert-authority
+		}
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "|") { // |1|salt|hash
+			continue
+		}
+		for _, host := range strings.Split(fields[0], ",") {
+			if strings.HasPrefix(host, "[") {
+				if end := strings.Index(host, "]"); end > 0 {
+					host = host[1:end]
+				}
+			}
+			if len(host) == 0 || strings.ContainsAny(host, "*?!") || stringInSlice(host, targets) {
+				continue
+			}
+			targets = append(targets, host)
+		}
+	}
+	return targets, nil
+}
+
+/*
+readAnsibleInventory extracts the hosts from an INI style Ansible inventory
+The ansible_host variable is used when present, otherwise the inventory host name
+
+Args:
+
+	fname: the inventory file name
+
+Returns:
+
+	a slice of targets
+*/
+func readAnsibleInventory(fname string) ([]string, error) {
+	lines, err := readTargetsFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	inHostSection := true
+	for _, line := range lines {
+		if strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			// [group:vars] and [group:children] sections do not list hosts
+			inHostSection = !strings.Contains(line, ":")
+			continue
+		}
+		if !inHostSection {
+			continue
+		}
+		fields := strings.Fields(line)
+		host := fields[0]
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "ansible_host=") {
+				host = strings.TrimPrefix(field, "ansible_host=")
+			}
+		}
+		if !stringInSlice(host, targets) {
+			targets = append(targets, host)
+		}
+	}
+	return targets, nil
+}
+
+/*
+readHostsFile extracts the pinned addresses from an /etc/hosts style file
+Loopback and unspecified addresses, which are used to block host names, are skipped
+
+Args:
+
+	fname: the file name, such as /etc/hosts
+
+Returns:
+
+	a slice of IP addresses, without duplicates
+
+	a slice of rows in this format: host name, IP address
+*/
+func readHostsFile(fname string) ([]string, [][]string, error) {
+	lines, err := readTargetsFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var targets []string
+	var origins [][]string
+	for _, line := range lines {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(strings.Split(fields[0], "%")[0])
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+			continue
+		}
+		if !stringInSlice(ip.String(), targets) {
+			targets = append(targets, ip.String())
+		}
+		for _, name := range fields[1:] {
+			origins = append(origins, []string{name, ip.String()})
+		}
+	}
+	return targets, origins, nil
+}
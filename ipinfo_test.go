@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A resolver that answers every A query with the same address
+type fakeResolver struct {
+	addr string
+}
+
+func (f fakeResolver) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	reply := new(dns.Msg)
+	reply.SetReply(msg)
+	if msg.Question[0].Qtype == dns.TypeA {
+		reply.Answer = append(reply.Answer, &dns.A{Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP(f.addr)})
+	}
+	return reply, nil
+}
+
+// A transport that answers ipinfo.io requests with a fixed org and country, or with 429 when limited is set
+type fakeIpinfo struct {
+	limited bool
+}
+
+func (f fakeIpinfo) RoundTrip(r *http.Request) (*http.Response, error) {
+	if f.limited {
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}, Body: io.NopCloser(strings.NewReader("rate limited")), Request: r}, nil
+	}
+	ip := strings.Split(strings.Trim(r.URL.Path, "/"), "/")[0]
+	body := `{"ip":"` + ip + `","city":"Berlin","country":"DE","org":"AS1 Example","loc":"52.5,13.4"}`
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+}
+
+// useFakes sends DNS queries and ipinfo.io requests to the fakes until the test ends
+func useFakes(t *testing.T, resolver encryptedResolver, transport http.RoundTripper) {
+	previousResolver, previousTransport := dnsResolver, http.DefaultClient.Transport
+	dnsResolver, http.DefaultClient.Transport = resolver, transport
+	t.Cleanup(func() {
+		dnsResolver, http.DefaultClient.Transport = previousResolver, previousTransport
+	})
+}
+
+// checkGoroutines fails the test when the goroutines started by f have not returned shortly after it
+func checkGoroutines(t *testing.T, f func()) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	f()
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second); after > before && time.Now().Before(deadline); after = runtime.NumGoroutine() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func TestLookupWorkersReturn(t *testing.T) {
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{})
+	checkGoroutines(t, func() {
+		for i := 0; i < 5; i++ {
+			ipAddrs, _, _ := runDNS(30, []string{"a.example", "b.example"}, false)
+			ipInfo, err := resolveAllIpInfoFunc(30, ipAddrs, nil, nil)
+			if err != nil || len(ipInfo) != 1 {
+				t.Fatalf("lookup: %v, %d results", err, len(ipInfo))
+			}
+		}
+	})
+}
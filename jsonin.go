@@ -0,0 +1,189 @@
+/*
+
+jsonin.go
+
+Support for -json-in and -json-path, which read the targets from structured JSON exports, such as
+SIEM dumps and cloud flow logs, without piping them through jq first. The file may hold a single
+JSON document or a stream of documents, one per line. The path is a subset of the jq syntax:
+
+	.events[].src_ip      the src_ip field of every element of the events array
+	.records[0].dst       the dst field of the first element of the records array
+	.["source.ip"]        a field whose name contains dots
+	.[]                   every element of a top level array
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A single step of a -json-path: a field name, an array index, or every array element when all is true
+type jsonStep struct {
+	field string
+	index int
+	all   bool
+	isKey bool
+}
+
+/*
+parseJSONPath converts a -json-path into steps
+
+Args:
+
+	path: such as .events[].src_ip
+
+Returns:
+
+	a slice of jsonStep
+*/
+func parseJSONPath(path string) ([]jsonStep, error) {
+	invalid := fmt.Errorf("invalid -json-path: %s", path)
+	if !strings.HasPrefix(path, ".") {
+		return nil, invalid
+	}
+	var steps []jsonStep
+	rest := path[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if len(rest) == 0 || rest[0] == '.' {
+				return nil, invalid
+			}
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, invalid
+			}
+			inside := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case len(inside) == 0:
+				steps = append(steps, jsonStep{all: true})
+			case inside[0] == '"':
+				name, err := strconv.Unquote(inside)
+				if err != nil {
+					return nil, invalid
+				}
+				steps = append(steps, jsonStep{field: name, isKey: true})
+			default:
+				index, err := strconv.Atoi(inside)
+				if err != nil {
+					return nil, invalid
+				}
+				steps = append(steps, jsonStep{index: index})
+			}
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			steps = append(steps, jsonStep{field: rest[:end], isKey: true})
+			rest = rest[end:]
+		}
+	}
+	return steps, nil
+}
+
+/*
+selectJSON collects the string values found at the end of steps
+
+Args:
+
+	value: a decoded JSON value
+
+	steps: the remaining steps, as returned by parseJSONPath
+
+	found: the values found so far
+
+Returns:
+
+	found with the string values appended; missing fields, nulls and values of other types are skipped
+*/
+func selectJSON(value interface{}, steps []jsonStep, found []string) []string {
+	if len(steps) == 0 {
+		if s, ok := value.(string); ok && len(strings.TrimSpace(s)) > 0 {
+			found = append(found, strings.TrimSpace(s))
+		}
+		return found
+	}
+	step := steps[0]
+	if step.isKey {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return found
+		}
+		return selectJSON(object[step.field], steps[1:], found)
+	}
+	array, ok := value.([]interface{})
+	if !ok {
+		return found
+	}
+	if step.all {
+		for _, element := range array {
+			found = selectJSON(element, steps[1:], found)
+		}
+		return found
+	}
+	index := step.index
+	if index < 0 {
+		index += len(array)
+	}
+	if index < 0 || index >= len(array) {
+		return found
+	}
+	return selectJSON(array[index], steps[1:], found)
+}
+
+/*
+readJSONTargets reads targets from a JSON file
+
+Args:
+
+	fname: the file name, or - for standard input
+
+	path: the -json-path of the targets
+
+Returns:
+
+	a slice of targets, in file order
+*/
+func readJSONTargets(fname string, path string) ([]string, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = os.Stdin
+	if fname != "-" {
+		file, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var targets []string
+	decoder := json.NewDecoder(r)
+	for {
+		var document interface{}
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", fname, err)
+		}
+		targets = selectJSON(document, steps, targets)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no strings found at %s", fname, path)
+	}
+	return targets, nil
+}
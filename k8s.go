@@ -0,0 +1,115 @@
+/*
+
+k8s.go
+
+The k8s subcommand geolocates the public entry points of the cluster in the current kube context:
+Ingress host names and the LoadBalancer IPs / host names of Services and Ingresses.
+The cluster is queried with kubectl, so its configuration and credentials are used as is.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+)
+
+// The subset of a Service or Ingress returned by: kubectl get -o json
+type kubeObject struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer struct {
+			Ingress []struct {
+				IP       string `json:"ip"`
+				Hostname string `json:"hostname"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+/*
+kubernetesTargets lists the external host names and IP addresses of all Services and Ingresses
+
+Args:
+
+	kubectl: the kubectl command to run
+
+Returns:
+
+	a slice of targets
+
+	a slice of rows in this format: namespace/kind/name, target
+*/
+func kubernetesTargets(kubectl string) ([]string, [][]string, error) {
+	out, err := exec.Command(kubectl, "get", "services,ingresses", "--all-namespaces", "-o", "json").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, nil, fmt.Errorf("%s: %s", kubectl, exitErr.Stderr)
+		}
+		return nil, nil, err
+	}
+	var list struct {
+		Items []kubeObject `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, nil, err
+	}
+
+	var targets []string
+	var objects [][]string
+	add := func(obj kubeObject, target string) {
+		if len(target) == 0 {
+			return
+		}
+		objects = append(objects, []string{obj.Metadata.Namespace + "/" + obj.Kind + "/" + obj.Metadata.Name, target})
+		if !stringInSlice(target, targets) {
+			targets = append(targets, target)
+		}
+	}
+	for _, obj := range list.Items {
+		for _, rule := range obj.Spec.Rules {
+			add(obj, rule.Host)
+		}
+		for _, lb := range obj.Status.LoadBalancer.Ingress {
+			add(obj, lb.IP)
+			add(obj, lb.Hostname)
+		}
+	}
+	return targets, objects, nil
+}
+
+/*
+outputTargetOrigins outputs a secondary table showing where each target came from, such as a
+cluster object or a DNS record
+
+Args:
+
+	w: where to write the table to
+
+	heading: the header of the first column
+
+	origins: a slice of rows in this format: origin, target
+*/
+func outputTargetOrigins(w io.Writer, heading string, origins [][]string) {
+	sort.Slice(origins, func(a, b int) bool {
+		return origins[a][0]+origins[a][1] < origins[b][0]+origins[b][1]
+	})
+	table := newTable(w)
+	table.SetHeader([]string{heading, "Input"})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(origins)
+	table.Render()
+}
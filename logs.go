@@ -0,0 +1,290 @@
+/*
+
+logs.go
+
+Support for the -zeek and -eve options, which enrich Zeek conn.log and Suricata eve.json files
+with the geolocation of each connection's responder / destination IP address.
+The logs are re-emitted with geo fields injected, or summarized with -log-report.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	logFormatZeek = "zeek"
+	logFormatEve  = "eve"
+)
+
+// the fields appended to Zeek logs, in order
+var zeekGeoFields = []string{"resp_country", "resp_city", "resp_org"}
+
+/*
+isPublicIP checks if an address could have geolocation data
+
+Args:
+
+	ip: an IP address
+
+Returns:
+
+	false for private, loopback, link-local, multicast and unspecified addresses
+*/
+func isPublicIP(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	return !(addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified())
+}
+
+/*
+logLineIP returns the responder / destination IP address of a single log line
+
+Args:
+
+	format: logFormatZeek or logFormatEve
+
+	line: a line from the log
+
+	zeekIndex: the column of id.resp_h in a Zeek TSV log, taken from its #fields header
+
+Returns:
+
+	the IP address, or an empty string if the line does not contain one
+*/
+func logLineIP(format string, line string, zeekIndex int) string {
+	if strings.HasPrefix(line, "{") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return ""
+		}
+		key := "dest_ip"
+		if format == logFormatZeek {
+			key = "id.resp_h"
+		}
+		ip, _ := record[key].(string)
+		return ip
+	}
+	if format != logFormatZeek || strings.HasPrefix(line, "#") || zeekIndex < 0 {
+		return ""
+	}
+	columns := strings.Split(line, "\t")
+	if zeekIndex >= len(columns) {
+		return ""
+	}
+	return columns[zeekIndex]
+}
+
+/*
+zeekFieldIndex returns the column of id.resp_h when line is a Zeek #fields header
+
+Args:
+
+	line: a line from the log
+
+	current: the column found so far, returned when line is not a #fields header
+
+Returns:
+
+	the column of id.resp_h, or -1 if the header does not contain it
+*/
+func zeekFieldIndex(line string, current int) int {
+	if !strings.HasPrefix(line, "#fields\t") {
+		return current
+	}
+	for i, field := range strings.Split(line, "\t")[1:] {
+		if field == "id.resp_h" {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+enrichLogLine injects geo fields into a single log line
+
+Args:
+
+	format: logFormatZeek or logFormatEve
+
+	line: a line from the log
+
+	info: the IP info of the line's responder / destination, or nil when unknown
+
+	zeekIndex: the column of id.resp_h in a Zeek TSV log
+
+Returns:
+
+	the enriched line
+*/
+func enrichLogLine(format string, line string, info *ipInfoResult, zeekIndex int) string {
+	values := []string{"-", "-", "-"}
+	if info != nil {
+		values = []string{info.Country, info.City, info.Org}
+	}
+
+	if strings.HasPrefix(line, "{") {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &record); err != nil || info == nil {
+			return line
+		}
+		if format == logFormatZeek {
+			for i, field := range zeekGeoFields {
+				record[field], _ = json.Marshal(values[i])
+			}
+		} else {
+			record["dest_geo"], _ = json.Marshal(map[string]string{"country": info.Country, "city": info.City, "region": info.Region, "org": info.Org, "loc": info.Loc})
+		}
+		enriched, err := json.Marshal(record)
+		if err != nil {
+			return line
+		}
+		return string(enriched)
+	}
+
+	if format != logFormatZeek {
+		return line
+	}
+	switch {
+	case strings.HasPrefix(line, "#fields\t"):
+		return line + "\t" + strings.Join(zeekGeoFields, "\t")
+	case strings.HasPrefix(line, "#types\t"):
+		return line + strings.Repeat("\tstring", len(zeekGeoFields))
+	case strings.HasPrefix(line, "#") || zeekIndex < 0:
+		return line
+	}
+	for i := range values {
+		if len(values[i]) == 0 {
+			values[i] = "-"
+		}
+		values[i] = strings.ReplaceAll(values[i], "\t", " ")
+	}
+	return line + "\t" + strings.Join(values, "\t")
+}
+
+/*
+runLogEnrichment reads a Zeek or Suricata log, looks up all public responder / destination addresses,
+and then either re-emits the log with geo fields or outputs a summary table
+
+Args:
+
+	fname: the log file name
+
+	format: logFormatZeek or logFormatEve
+
+	report: output a summary of connections per country and org instead of the enriched log
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an error if the log can not be read
+*/
+func runLogEnrichment(fname string, format string, report bool, workers int, cache ipCache) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the first pass only collects the addresses, so that large logs are not held in memory
+	var ipAddrs []string
+	hits := make(map[string]int) // key=IP address, value=number of lines
+	zeekIndex := -1
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		zeekIndex = zeekFieldIndex(line, zeekIndex)
+		ip := logLineIP(format, line, zeekIndex)
+		if len(ip) == 0 || ip == "-" {
+			continue
+		}
+		if _, seen := hits[ip]; !seen && isPublicIP(ip) {
+			ipAddrs = append(ipAddrs, ip)
+		}
+		hits[ip]++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	infos := make(map[string]*ipInfoResult)
+	for _, info := range resolveAllIpInfo(workers, ipAddrs, cache) {
+		info := info
+		infos[info.Ip] = &info
+	}
+
+	if report {
+		counts := make(map[[2]string]int)
+		for ip, n := range hits {
+			key := [2]string{"N/A", "N/A"}
+			if info := infos[ip]; info != nil {
+				key = [2]string{info.Country, info.Org}
+			}
+			counts[key] += n
+		}
+		outputLogReport(counts, "Connections")
+		return nil
+	}
+
+	// the second pass reads the log again to emit each line with its geo fields
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("%s: the log is read twice, so it must be a regular file: %w", fname, err)
+	}
+	zeekIndex = -1
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	scanner = bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		zeekIndex = zeekFieldIndex(line, zeekIndex)
+		fmt.Fprintln(writer, enrichLogLine(format, line, infos[logLineIP(format, line, zeekIndex)], zeekIndex))
+	}
+	return scanner.Err()
+}
+
+/*
+outputLogReport outputs the number of connections or hits per country and org, largest first
+
+Args:
+
+	counts: a map where key=country and org, value=number of connections or hits
+
+	unit: the name of the count column, such as Connections
+*/
+func outputLogReport(counts map[[2]string]int, unit string) {
+	var allRows [][]string
+	for key, count := range counts {
+		allRows = append(allRows, []string{key[0], key[1], strconv.Itoa(count)})
+	}
+	sort.Slice(allRows, func(a, b int) bool {
+		countA, _ := strconv.Atoi(allRows[a][2])
+		countB, _ := strconv.Atoi(allRows[b][2])
+		if countA != countB {
+			return countA > countB
+		}
+		return allRows[a][0]+allRows[a][1] < allRows[b][0]+allRows[b][1]
+	})
+
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"Country", "Org", unit})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(allRows)
+	table.Render()
+}
@@ -0,0 +1,186 @@
+/*
+
+memcache.go
+
+An in-process cache used by the serve subcommand in front of the -cache cache. Entries are fresh for
+-memory-ttl, then served stale for up to -stale while they are refreshed in the background, so clients
+never wait for ipinfo.io once an IP address has been seen. Concurrent lookups of the same IP address
+share a single upstream request, so that a burst of identical requests costs one call to ipinfo.io.
+When a refresh fails, such as when ipinfo.io rate limits the service, the failure is logged and the stale
+entry is kept and served until a later refresh succeeds.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// how long a stale entry is served before a failed refresh is retried, unless ipinfo.io gave a Retry-After
+const refreshRetryDelay = time.Minute
+
+// An upstream lookup in progress; done is closed once info is set
+type pendingLookup struct {
+	done chan struct{}
+	info ipInfoResult
+}
+
+// An ipCache kept in memory, with stale-while-revalidate and request coalescing
+type memoryCache struct {
+	inner    ipCache // the -cache cache, or nil
+	ttl      time.Duration
+	staleFor time.Duration
+	mu       sync.Mutex
+	entries  map[string]cacheEntry
+	pending  map[string]*pendingLookup
+}
+
+/*
+newMemoryCache creates an in-process cache
+
+Args:
+
+	inner: the cache to look up entries in before calling ipinfo.io, or nil
+
+	ttl: how long entries are fresh
+
+	staleFor: how long expired entries are still served while being refreshed
+
+Returns:
+
+	a pointer to a memoryCache struct
+*/
+func newMemoryCache(inner ipCache, ttl time.Duration, staleFor time.Duration) *memoryCache {
+	return &memoryCache{inner: inner, ttl: ttl, staleFor: staleFor, entries: make(map[string]cacheEntry), pending: make(map[string]*pendingLookup)}
+}
+
+/*
+get returns the entry of an IP address. Stale entries are returned as fresh while a refresh runs in the
+background, and missing entries are looked up before returning, so that lookupIpInfo never calls
+ipinfo.io itself. Failed lookups are returned to all waiting callers but are not cached, unless an older
+entry of the IP address is kept, which is then returned instead.
+*/
+func (c *memoryCache) get(ip string) (cacheEntry, bool) {
+	c.mu.Lock()
+	entry, found := c.entries[ip]
+	now := time.Now()
+	if found && now.Before(entry.Expires) {
+		c.mu.Unlock()
+		return entry, true
+	}
+	if found && now.Before(entry.Expires.Add(c.staleFor)) {
+		c.fetch(ip)
+		c.mu.Unlock()
+		entry.Expires = now.Add(time.Second)
+		return entry, true
+	}
+	pending := c.fetch(ip)
+	c.mu.Unlock()
+
+	<-pending.done
+	if len(pending.info.Ip) == 0 {
+		c.mu.Lock()
+		entry, found = c.entries[ip]
+		c.mu.Unlock()
+		if found {
+			return entry, true
+		}
+	}
+	return cacheEntry{Info: pending.info, Expires: time.Now().Add(time.Second)}, true
+}
+
+/*
+fetch starts an upstream lookup of an IP address, unless one is already in progress; the caller must hold c.mu
+
+Args:
+
+	ip: an IP address
+
+Returns:
+
+	the lookup in progress
+*/
+func (c *memoryCache) fetch(ip string) *pendingLookup {
+	if pending, ok := c.pending[ip]; ok {
+		return pending
+	}
+	pending := &pendingLookup{done: make(chan struct{})}
+	c.pending[ip] = pending
+	go func() {
+		info := lookupIpInfo(ip, c.inner)
+		c.mu.Lock()
+		if len(info.Ip) > 0 {
+			c.entries[ip] = cacheEntry{Info: info, Expires: time.Now().Add(c.ttl)}
+		} else if entry, ok := c.entries[ip]; ok {
+			// keep serving the stale entry; the refresh is retried once it expires again
+			delay := refreshRetryDelay
+			var limited *rateLimitError
+			if errors.As(info.ErrMsg, &limited) && limited.retryAfter > 0 {
+				delay = limited.retryAfter
+			}
+			entry.Expires = time.Now().Add(delay)
+			c.entries[ip] = entry
+			fmt.Fprintf(os.Stderr, "refresh error: %s: %v; the stale entry is served for another %s\n", ip, info.ErrMsg, delay)
+		}
+		delete(c.pending, ip)
+		c.mu.Unlock()
+		pending.info = info
+		close(pending.done)
+	}()
+	return pending
+}
+
+/*
+contains reports if an IP address has an entry that can be served, fresh or stale
+
+Args:
+
+	ip: an IP address
+
+Returns:
+
+	true when get will not wait for ipinfo.io
+*/
+func (c *memoryCache) contains(ip string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[ip]
+	return found && time.Now().Before(entry.Expires.Add(c.staleFor))
+}
+
+func (c *memoryCache) set(ip string, entry cacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.Expires = time.Now().Add(c.ttl)
+	c.entries[ip] = entry
+	return nil
+}
+
+func (c *memoryCache) refresh() bool {
+	return false
+}
+
+/*
+pruneEvery periodically removes entries that are too old to be served stale
+
+Args:
+
+	interval: the time between prunes
+*/
+func (c *memoryCache) pruneEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.mu.Lock()
+		now := time.Now()
+		for ip, entry := range c.entries {
+			if now.After(entry.Expires.Add(c.staleFor)) {
+				delete(c.entries, ip)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,68 @@
+/*
+
+mx.go
+
+Support for -mx, which looks up the mail servers of a domain: its MX records are resolved, and each
+mail server is resolved and geolocated, with the priority of its MX record in the MX Priority column,
+so that mail admins can see where a correspondent's mail infrastructure is located.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+/*
+readMX finds the mail servers of a domain; a domain without MX records receives its mail itself (RFC 5321)
+
+Args:
+
+	domain: the domain name
+
+Returns:
+
+	the host names of the mail servers, by priority
+
+	a map with key=host name, value=the priority of its MX record; lower values are preferred
+*/
+func readMX(domain string) ([]string, map[string]uint16, error) {
+	domain = normalizeTarget(argHost(strings.TrimSpace(domain)))
+	answers, err := queryDNS(domain, dns.TypeMX)
+	if err != nil {
+		return nil, nil, err
+	}
+	var hosts []string
+	priorities := make(map[string]uint16)
+	for _, rr := range answers {
+		mx, ok := rr.(*dns.MX)
+		if !ok { // skip CNAME records that lead to the MX records
+			continue
+		}
+		host := normalizeTarget(unicodeHost(mx.Mx))
+		if len(host) == 0 { // a null MX record (RFC 7505)
+			return nil, nil, fmt.Errorf("%s does not accept mail: it has a null MX record", domain)
+		}
+		if previous, ok := priorities[host]; !ok || mx.Preference < previous {
+			priorities[host] = mx.Preference
+		}
+		if !stringInSlice(host, hosts) {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s has no MX records, so its mail is delivered to the domain itself\n", domain)
+		hosts = []string{domain}
+		priorities[domain] = 0
+	}
+	sort.SliceStable(hosts, func(a, b int) bool {
+		return priorities[hosts[a]] < priorities[hosts[b]]
+	})
+	return hosts, priorities, nil
+}
@@ -0,0 +1,358 @@
+/*
+
+myip.go
+
+The myip subcommand, which reports the external IPv4 and IPv6 address of this machine with their location,
+reverse DNS name and the addresses of the local interfaces. When the external address is not assigned
+to an interface, the machine is behind NAT; when an interface address is in 100.64.0.0/10, the NAT is
+carrier-grade NAT (CGNAT) of the internet provider, so that inbound connections are not possible.
+With -gateway, the external address reported by the router is compared too, see gateway.go. With -watch,
+the detection is repeated and changes of the IP address, ASN or country are reported, see notify.go.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the shared address space of RFC 6598, used by internet providers for carrier-grade NAT
+var cgnatNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// The external address of one IP version
+type myIPResult struct {
+	Family string `json:"family"`
+	ipInfoResult
+	ReverseDNS string   `json:"reverse_dns"`
+	Interfaces []string `json:"interface_addresses"`
+	NAT        string   `json:"nat"` // none, nat, cgnat or double
+	Gateway    string   `json:"gateway_external_ip,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+/*
+interfaceAddresses returns the addresses of the local interfaces, without loopback and link-local addresses
+
+Args:
+
+	network: tcp4 for IPv4 addresses, or tcp6 for IPv6 addresses
+
+Returns:
+
+	a slice of IP addresses
+*/
+func interfaceAddresses(network string) ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() || (ipNet.IP.To4() != nil) != (network == "tcp4") {
+			continue
+		}
+		found = append(found, ipNet.IP.String())
+	}
+	return found, nil
+}
+
+/*
+natType determines how the external address relates to the interface addresses
+
+Args:
+
+	external: the external IP address
+
+	interfaces: the addresses of the local interfaces
+
+Returns:
+
+	none when external is assigned to an interface, cgnat when an interface is in 100.64.0.0/10, otherwise nat
+*/
+func natType(external string, interfaces []string) string {
+	if stringInSlice(external, interfaces) {
+		return "none"
+	}
+	for _, addr := range interfaces {
+		if cgnatNetwork.Contains(net.ParseIP(addr)) {
+			return "cgnat"
+		}
+	}
+	return "nat"
+}
+
+/*
+detectMyIP finds and describes the external address of one IP version
+
+Args:
+
+	network: tcp4 for the IPv4 address, or tcp6 for the IPv6 address
+
+	cache: the cache to use, or nil
+
+	gateway: also ask the router for its external IPv4 address
+
+Returns:
+
+	a myIPResult struct; Error is set when no external address was found
+*/
+func detectMyIP(network string, cache ipCache, gateway bool) myIPResult {
+	result := myIPResult{Family: ipVersion(network)}
+	result.Interfaces, _ = interfaceAddresses(network)
+	ip, warnings, err := detectExternalIP(network)
+	result.Warnings = warnings
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.ipInfoResult = lookupIpInfo(ip, cache)
+	exitOnRateLimit(result.ErrMsg)
+	result.Ip = ip
+	if names, err := net.LookupAddr(ip); err == nil && len(names) > 0 {
+		result.ReverseDNS = strings.TrimSuffix(names[0], ".")
+	}
+	result.NAT = natType(ip, result.Interfaces)
+	if gateway && network == "tcp4" {
+		routerIP, method, err := gatewayExternalIP()
+		if err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		} else {
+			result.Gateway = routerIP + " (" + method + ")"
+			if routerIP != ip {
+				result.NAT = "double"
+				result.Warnings = append(result.Warnings, fmt.Sprintf("double NAT: the external address of the gateway, %s, is not the external address %s seen by the internet", routerIP, ip))
+			}
+		}
+	}
+	return result
+}
+
+/*
+detectAllMyIP detects the external addresses of several IP versions at once
+
+Args:
+
+	networks: tcp4 and/or tcp6
+
+	cache: the cache to use, or nil
+
+	gateway: also ask the router for its external IPv4 address
+
+Returns:
+
+	a slice of myIPResult structs, in the order of networks
+*/
+func detectAllMyIP(networks []string, cache ipCache, gateway bool) []myIPResult {
+	results := make([]myIPResult, len(networks))
+	var wg sync.WaitGroup
+	for i, network := range networks {
+		wg.Add(1)
+		go func(i int, network string) {
+			defer wg.Done()
+			results[i] = detectMyIP(network, cache, gateway)
+		}(i, network)
+	}
+	wg.Wait()
+	return results
+}
+
+/*
+myIPChanges compares two detections of the same IP version
+
+Args:
+
+	previous: the earlier detection
+
+	current: the later detection
+
+Returns:
+
+	a description of each changed field among the IP address, ASN and country; empty when either
+	detection failed, so that a short outage is not reported as a change
+*/
+func myIPChanges(previous myIPResult, current myIPResult) []string {
+	if len(previous.Error) > 0 || len(current.Error) > 0 {
+		return nil
+	}
+	var changes []string
+	compare := func(name string, old string, new string) {
+		if old != new {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", name, orNA(old), orNA(new)))
+		}
+	}
+	compare("IP", previous.Ip, current.Ip)
+	compare("ASN", asNumber(previous.Org), asNumber(current.Org))
+	compare("country", previous.Country, current.Country)
+	return changes
+}
+
+/*
+watchMyIP detects the external addresses again every interval and reports when they change; it only returns on error
+
+Args:
+
+	networks: tcp4 and/or tcp6
+
+	cache: the cache to use, or nil
+
+	gateway: also ask the router for its external IPv4 address
+
+	previous: the results of the first detection
+
+	interval: the time between detections
+
+	desktop: also show a desktop notification for each change
+
+	webhook: also post each change to this URL, or empty
+
+	jsonOutput: write each change as a JSON line instead of text
+*/
+func watchMyIP(networks []string, cache ipCache, gateway bool, previous []myIPResult, interval time.Duration, desktop bool, webhook string, jsonOutput bool) error {
+	for {
+		time.Sleep(interval)
+		current := detectAllMyIP(networks, cache, gateway)
+		now := time.Now()
+		for i := range current {
+			changes := myIPChanges(previous[i], current[i])
+			if len(current[i].Error) == 0 {
+				previous[i] = current[i]
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			title := fmt.Sprintf("%s external address changed", current[i].Family)
+			message := strings.Join(changes, ", ")
+			if current[i].Org != "" {
+				message += " (" + current[i].Org + ")"
+			}
+			if jsonOutput {
+				event := map[string]interface{}{"time": now.Format(time.RFC3339), "changes": changes, "result": current[i]}
+				if err := json.NewEncoder(os.Stdout).Encode(event); err != nil {
+					return err
+				}
+			} else {
+				fmt.Printf("%s %s: %s\n", now.Format(time.RFC3339), title, message)
+			}
+			if desktop {
+				if err := desktopNotify(title, message); err != nil {
+					fmt.Fprintln(os.Stderr, "warning:", err)
+				}
+			}
+			if len(webhook) > 0 {
+				if err := postWebhook(webhook, title+": "+message); err != nil {
+					fmt.Fprintln(os.Stderr, "warning:", err)
+				}
+			}
+		}
+	}
+}
+
+/*
+runMyIP implements the myip subcommand
+
+Args:
+
+	args: the command line arguments after myip
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an error when neither an IPv4 nor an IPv6 external address was found
+*/
+func runMyIP(args []string, cache ipCache) error {
+	flags := flag.NewFlagSet("myip", flag.ContinueOnError)
+	v4Flag := flags.Bool("4", false, "only report the IPv4 address")
+	v6Flag := flags.Bool("6", false, "only report the IPv6 address")
+	jsonFlag := flags.Bool("json", false, "output JSON instead of a table")
+	gatewayFlag := flags.Bool("gateway", false, "ask the router for its external IPv4 address with NAT-PMP or UPnP and flag double NAT")
+	watchFlag := flags.Duration("watch", 0, "detect the external addresses again at this interval, such as 10m, and report when the IP, ASN or country changes")
+	notifyFlag := flags.Bool("notify", false, "with -watch, also show a desktop notification for each change")
+	webhookFlag := flags.String("webhook", envString("IPINFO_WEBHOOK", ""), "with -watch, also post each change as JSON {\"text\": ...} to this URL, such as a Slack or Mattermost incoming webhook; env: IPINFO_WEBHOOK")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *watchFlag < 0 || (*watchFlag == 0 && *notifyFlag) {
+		return fmt.Errorf("-notify requires a positive -watch interval")
+	}
+
+	var networks []string
+	if *v4Flag || !*v6Flag {
+		networks = append(networks, "tcp4")
+	}
+	if *v6Flag || !*v4Flag {
+		networks = append(networks, "tcp6")
+	}
+	results := detectAllMyIP(networks, cache, *gatewayFlag)
+	found := false
+	for _, result := range results {
+		found = found || len(result.Error) == 0
+	}
+
+	if *jsonFlag {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			return err
+		}
+	} else {
+		header := []string{"Field"}
+		type myIPField struct {
+			name  string
+			value func(myIPResult) string
+		}
+		fields := []myIPField{
+			{"IP", func(r myIPResult) string { return r.Ip }},
+			{"Reverse DNS", func(r myIPResult) string { return r.ReverseDNS }},
+			{"Org", func(r myIPResult) string { return r.Org }},
+			{"City", func(r myIPResult) string { return r.City }},
+			{"Region", func(r myIPResult) string { return r.Region }},
+			{"Country", func(r myIPResult) string { return r.Country }},
+			{"Loc", func(r myIPResult) string { return r.Loc }},
+			{"Interfaces", func(r myIPResult) string { return strings.Join(r.Interfaces, ", ") }},
+			{"NAT", func(r myIPResult) string { return r.NAT }},
+		}
+		if *gatewayFlag {
+			fields = append(fields, myIPField{"Gateway External", func(r myIPResult) string { return r.Gateway }})
+		}
+		allRows := make([][]string, len(fields))
+		for i, field := range fields {
+			allRows[i] = []string{field.name}
+		}
+		for _, result := range results {
+			header = append(header, result.Family)
+			for i, field := range fields {
+				value := field.value(result)
+				if len(result.Error) > 0 && i == 0 {
+					value = result.Error
+				}
+				allRows[i] = append(allRows[i], orNA(value))
+			}
+		}
+		table := newTable(os.Stdout)
+		table.SetHeader(header)
+		table.SetAutoWrapText(false)
+		table.AppendBulk(allRows)
+		table.Render()
+	}
+	for _, result := range results {
+		for _, warning := range result.Warnings {
+			fmt.Fprintln(os.Stderr, "warning:", warning)
+		}
+	}
+	if *watchFlag > 0 {
+		return watchMyIP(networks, cache, *gatewayFlag, results, *watchFlag, *notifyFlag, *webhookFlag, *jsonFlag)
+	}
+	if !found {
+		return fmt.Errorf("no external IP address found")
+	}
+	return nil
+}
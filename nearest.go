@@ -0,0 +1,95 @@
+/*
+
+nearest.go
+
+Support for the -nearest option, which adds the nearest major city and the nearest internet exchange (IXP)
+to each result. Both datasets are embedded into the program from the data directory.
+
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//go:embed data/cities.csv
+var citiesCSV string
+
+//go:embed data/ixps.csv
+var ixpsCSV string
+
+// A named location from one of the embedded datasets
+type place struct {
+	name    string
+	country string
+	lat     float64
+	lon     float64
+}
+
+/*
+parsePlaces converts an embedded dataset into a slice of places; lines starting with # are ignored
+
+Args:
+
+	data: CSV in this format: name,country,latitude,longitude
+
+Returns:
+
+	a slice of place structs
+*/
+func parsePlaces(data string) ([]place, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 4
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var places []place
+	for _, record := range records {
+		lat, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		lon, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, place{name: record[0], country: record[1], lat: lat, lon: lon})
+	}
+	return places, nil
+}
+
+/*
+nearestPlace finds the place closest to the given location
+
+Args:
+
+	places: a slice of place structs
+
+	loc: a location in "lat, lon" format
+
+Returns:
+
+	a string such as "Atlanta, US (12.34 mi)", or "N/A" when loc is not known
+*/
+func nearestPlace(places []place, loc string) string {
+	if !hasLocation(loc) || len(places) == 0 {
+		return "N/A"
+	}
+	lat, lon := latlon2coord(loc)
+	best := -1
+	bestMiles := 0.0
+	for i, p := range places {
+		miles := HaversineDistance(lat, lon, p.lat, p.lon)
+		if best == -1 || miles < bestMiles {
+			best, bestMiles = i, miles
+		}
+	}
+	return fmt.Sprintf("%s, %s (%.2f mi)", places[best].name, places[best].country, bestMiles)
+}
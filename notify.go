@@ -0,0 +1,88 @@
+/*
+
+notify.go
+
+Desktop notifications and webhooks, used by myip -watch to alert when the external address changes.
+Desktop notifications use notify-send on Linux and the BSDs, and osascript on macOS.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/*
+desktopNotify shows a desktop notification
+
+Args:
+
+	title: the title of the notification
+
+	message: the text of the notification
+
+Returns:
+
+	an error when notifications are not supported on this system or could not be shown
+*/
+func desktopNotify(title string, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// AppleScript strings only escape backslashes and double quotes
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+		script := fmt.Sprintf(`display notification "%s" with title "%s"`, quote.Replace(message), quote.Replace(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		return fmt.Errorf("desktop notifications are not supported on %s, use -webhook", runtime.GOOS)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=ipinfo", title, message)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if detail := strings.TrimSpace(string(out)); len(detail) > 0 {
+			return fmt.Errorf("desktop notification: %v: %s", err, detail)
+		}
+		return fmt.Errorf("desktop notification: %w", err)
+	}
+	return nil
+}
+
+/*
+postWebhook posts a message as JSON {"text": message}, as accepted by Slack and Mattermost incoming webhooks
+
+Args:
+
+	url: the webhook URL
+
+	message: the text to post
+
+Returns:
+
+	an error when the webhook did not accept the message
+*/
+func postWebhook(url string, message string) error {
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+
+oneline.go
+
+Support for -format oneline, which outputs one compact line per result for chat messages and quick shell use:
+
+	gatech.edu → 130.207.160.173 🇺🇸 Atlanta, Georgia (AS2637 Georgia Institute of Technology) 231 mi
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+countryFlag converts a two letter country code into its flag emoji
+
+Args:
+
+	country: a two letter country code, such as: US
+
+Returns:
+
+	the flag emoji, or an empty string for an invalid country code
+*/
+func countryFlag(country string) string {
+	if len(country) != 2 {
+		return ""
+	}
+	var flag strings.Builder
+	for _, r := range strings.ToUpper(country) {
+		if r < 'A' || r > 'Z' {
+			return ""
+		}
+		flag.WriteRune(0x1F1E6 + r - 'A') // regional indicator symbol
+	}
+	return flag.String()
+}
+
+/*
+formatOneline formats a result as a single line
+
+Args:
+
+	row: a resultRow struct
+
+	precision: the distance precision; whole miles when nil
+
+Returns:
+
+	the line, without a trailing newline
+*/
+func formatOneline(row resultRow, precision *outputPrecision) string {
+	var parts []string
+	if row.Input != row.Ip {
+		parts = append(parts, row.Input, "→")
+	}
+	parts = append(parts, row.Ip)
+	if !lookupSucceeded(row) {
+		return strings.Join(append(parts, "(no info)"), " ")
+	}
+	if flag := countryFlag(row.Country); len(flag) > 0 {
+		parts = append(parts, flag)
+	}
+	var place []string
+	for _, name := range []string{row.City, row.Region} {
+		if len(name) > 0 {
+			place = append(place, name)
+		}
+	}
+	if len(place) == 0 {
+		place = append(place, row.Country)
+	}
+	parts = append(parts, strings.Join(place, ", "))
+	if len(row.Org) > 0 {
+		parts = append(parts, "("+row.Org+")")
+	}
+	if row.Distance != nil {
+		distance := fmt.Sprintf("%.0f", *row.Distance)
+		if precision != nil {
+			distance = precision.formatDistance(*row.Distance)
+		}
+		parts = append(parts, distance+" mi")
+	}
+	return strings.Join(parts, " ")
+}
+
+/*
+outputOneline outputs one line per row
+
+Args:
+
+	w: where to write the output to
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	precision: the distance precision; whole miles when nil
+
+Returns:
+
+	an error if the output could not be written
+*/
+func outputOneline(w io.Writer, rows []resultRow, precision *outputPrecision) error {
+	writer := bufio.NewWriter(w)
+	for _, row := range rows {
+		fmt.Fprintln(writer, formatOneline(row, precision))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,150 @@
+/*
+
+openapi.go
+
+The OpenAPI 3 document published by the serve subcommand at GET /openapi.json, so that clients can be
+generated instead of written by hand. The result schema is derived from resultRow, the same as
+-describe-output, so that it always matches the JSON output.
+
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+/*
+schemaOf converts the type of an outputField into an OpenAPI schema
+
+Args:
+
+	field: an outputField as returned by describeFields
+
+Returns:
+
+	an OpenAPI schema object
+*/
+func schemaOf(field outputField) map[string]interface{} {
+	schema := map[string]interface{}{"description": field.Description}
+	if item, found := strings.CutPrefix(field.Type, "array of "); found {
+		schema["type"] = "array"
+		schema["items"] = map[string]interface{}{"type": item}
+	} else {
+		schema["type"] = field.Type
+	}
+	if field.Nullable {
+		schema["nullable"] = true
+	}
+	return schema
+}
+
+/*
+openAPISpec returns the OpenAPI document of the serve subcommand
+
+Args:
+
+	withKeys: when true, the document requires an API key for /lookup and includes /usage
+
+Returns:
+
+	the OpenAPI document, ready to be encoded as JSON
+*/
+func openAPISpec(withKeys bool) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for _, field := range describeFields(reflect.TypeOf(resultRow{})) {
+		properties[field.Name] = schemaOf(field)
+		if !field.Nullable && len(field.Flag) == 0 {
+			required = append(required, field.Name)
+		}
+	}
+
+	text := func(description string) map[string]interface{} {
+		return map[string]interface{}{"description": description, "content": map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}}
+	}
+	jsonResponse := func(description string, schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"description": description, "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}}
+	}
+
+	lookup := map[string]interface{}{
+		"operationId": "lookup",
+		"summary":     "Look up the IP info of hostnames, IP addresses and CIDR ranges",
+		"parameters": []interface{}{map[string]interface{}{
+			"name":        "q",
+			"in":          "query",
+			"required":    true,
+			"description": "comma separated targets; may be given more than once",
+			"style":       "form",
+			"explode":     false,
+			"schema":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		}},
+		"responses": map[string]interface{}{
+			"200": jsonResponse("one result per IP address", map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Result"}}),
+			"400": text("the q parameter is missing, or has more targets than allowed"),
+			"502": text("every lookup failed upstream; see the Retry-After header"),
+			"503": text("the service's own location is not known yet, or ipinfo.io rate limited the service; see the Retry-After header"),
+		},
+	}
+	stream := map[string]interface{}{
+		"operationId": "stream",
+		"summary":     "The same as lookup, with one JSON result per line output as each lookup completes",
+		"parameters":  lookup["parameters"],
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "one result per line", "content": map[string]interface{}{"application/x-ndjson": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Result"}}}},
+			"400": text("the q parameter is missing, or has more targets than allowed"),
+			"503": text("the service's own location is not known yet, or ipinfo.io rate limited the service before any result was output"),
+		},
+	}
+	paths := map[string]interface{}{
+		"/lookup":  map[string]interface{}{"get": lookup},
+		"/stream":  map[string]interface{}{"get": stream},
+		"/healthz": map[string]interface{}{"get": map[string]interface{}{"operationId": "healthz", "summary": "Liveness check", "responses": map[string]interface{}{"200": text("the process is running")}}},
+		"/readyz":  map[string]interface{}{"get": map[string]interface{}{"operationId": "readyz", "summary": "Readiness check", "responses": map[string]interface{}{"200": text("the service is ready"), "503": text("the service is not ready yet")}}},
+	}
+	schemas := map[string]interface{}{
+		"Result": map[string]interface{}{"type": "object", "properties": properties, "required": required},
+	}
+	components := map[string]interface{}{"schemas": schemas}
+
+	if withKeys {
+		schemas["Usage"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"requests":  map[string]interface{}{"type": "integer", "description": "the number of requests with the key"},
+				"lookups":   map[string]interface{}{"type": "integer", "description": "the number of IP addresses looked up"},
+				"rejected":  map[string]interface{}{"type": "integer", "description": "the number of requests rejected by the rate limit"},
+				"last_used": map[string]interface{}{"type": "string", "format": "date-time"},
+			},
+		}
+		components["securitySchemes"] = map[string]interface{}{
+			"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+		security := []interface{}{map[string]interface{}{"bearer": []string{}}, map[string]interface{}{"apiKey": []string{}}}
+		for _, operation := range []map[string]interface{}{lookup, stream} {
+			responses := operation["responses"].(map[string]interface{})
+			responses["401"] = text("the API key is missing or invalid")
+			responses["429"] = text("the rate limit of the API key was exceeded, which is charged one lookup per IP address; see the Retry-After header")
+			operation["security"] = security
+		}
+		paths["/usage"] = map[string]interface{}{"get": map[string]interface{}{
+			"operationId": "usage",
+			"summary":     "The usage of the caller's API key",
+			"security":    security,
+			"responses": map[string]interface{}{
+				"200": jsonResponse("the usage of the key's team", map[string]interface{}{"$ref": "#/components/schemas/Usage"}),
+				"401": text("the API key is missing or invalid"),
+				"429": text("the rate limit of the API key was exceeded; see the Retry-After header"),
+			},
+		}}
+	}
+
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]interface{}{"title": "ipinfo", "version": pgmVersion, "description": pgmUrl},
+		"paths":      paths,
+		"components": components,
+	}
+}
@@ -0,0 +1,67 @@
+/*
+
+portal.go
+
+The web page served by the serve subcommand at /: paste a list of hosts, watch the results arrive from
+/stream, view them on a map and download them as CSV. The page is embedded in the binary, so the
+service needs no extra deployment. The map is drawn with Leaflet and OpenStreetMap tiles, the same as
+-format html, so it needs internet access in the browser.
+
+The page is public on purpose, even with -keys: it is static and contains no results, and a browser can
+not send an API key when it opens it. The key is entered on the page and sent with each /stream request,
+which is where it is checked.
+
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+//go:embed data/portal.html
+var portalHTML []byte
+
+// handlePortal implements /; it does not require an API key, see the description at the top of this file
+func (srv *server) handlePortal(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(portalHTML)
+}
+
+// handleStream implements /stream; name is the team of the API key, or empty when keys are not required
+func (srv *server) handleStream(w http.ResponseWriter, r *http.Request, name string) {
+	targets, ok := srv.requestTargets(w, r)
+	if !ok {
+		return
+	}
+
+	ipAddrs, reverseIP, _ := runDNS(srv.workers, normalizedTargets(targets), false)
+	if srv.keys != nil {
+		if !srv.keys.allowLookups(w, name, len(ipAddrs)) {
+			return
+		}
+		srv.keys.countLookups(name, len(ipAddrs))
+	}
+	srv.audit.record("serve", name, r.RemoteAddr, targets, ipAddrs, srv.audit.cachedBefore(srv.cache, ipAddrs))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written := false
+	_, err := resolveAllIpInfoFunc(srv.workers, ipAddrs, srv.cache, func(info ipInfoResult) {
+		written = true
+		encoder.Encode(buildRow(info, reverseIP, srv.loc, extraColumns{feeds: srv.feeds.Load()}))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	// once rows were streamed the status can no longer be changed, so the stream just ends early
+	if !written {
+		replyLookupError(w, nil, err)
+	}
+}
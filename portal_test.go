@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamWorkersReturn(t *testing.T) {
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{})
+	srv := &server{workers: 30, maxTargets: 10}
+	srv.ready.Store(true)
+	checkGoroutines(t, func() {
+		for i := 0; i < 5; i++ {
+			w := httptest.NewRecorder()
+			srv.handleStream(w, httptest.NewRequest("GET", "/stream?q=a.example,b.example", nil), "")
+			if !strings.Contains(w.Body.String(), `"country":"DE"`) {
+				t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+			}
+		}
+	})
+}
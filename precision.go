@@ -0,0 +1,146 @@
+/*
+
+precision.go
+
+Support for -precision, which controls the numeric detail of distances and coordinates, such as:
+
+	-precision distance=0          whole miles
+	-precision distance=~100       round to the nearest 100 miles, displayed as ~1,200
+	-precision coords=2            truncate coordinates to 2 decimals, about 1 km, for privacy
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The numeric detail of output values; see parsePrecision
+type outputPrecision struct {
+	distanceDecimals int
+	distanceStep     float64
+	coordDecimals    int
+}
+
+/*
+parsePrecision converts the value of -precision into an outputPrecision struct
+
+Args:
+
+	spec: a comma separated list of: distance=<decimals>, distance=~<step> and coords=<decimals>
+
+Returns:
+
+	a pointer to an outputPrecision struct; nil when spec is empty, which keeps the default of 2 decimal distances
+	and unchanged coordinates
+*/
+func parsePrecision(spec string) (*outputPrecision, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	precision := &outputPrecision{distanceDecimals: 2, coordDecimals: -1}
+	for _, setting := range strings.Split(spec, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(setting), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid -precision setting: %s", setting)
+		}
+		var err error
+		switch name {
+		case "distance":
+			if strings.HasPrefix(value, "~") {
+				precision.distanceStep, err = strconv.ParseFloat(value[1:], 64)
+				if err == nil && precision.distanceStep <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+			} else {
+				precision.distanceDecimals, err = strconv.Atoi(value)
+				if err == nil && precision.distanceDecimals < 0 {
+					err = fmt.Errorf("must not be negative")
+				}
+			}
+		case "coords":
+			precision.coordDecimals, err = strconv.Atoi(value)
+			if err == nil && precision.coordDecimals < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		default:
+			return nil, fmt.Errorf("unknown -precision setting: %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid -precision value for %s: %s: %v", name, value, err)
+		}
+	}
+	return precision, nil
+}
+
+/*
+roundDistance rounds a distance to the configured number of decimals or step
+
+Args:
+
+	miles: a distance in miles
+
+Returns:
+
+	the rounded distance
+*/
+func (p *outputPrecision) roundDistance(miles float64) float64 {
+	if p == nil {
+		return miles
+	}
+	if p.distanceStep > 0 {
+		return math.Round(miles/p.distanceStep) * p.distanceStep
+	}
+	scale := math.Pow(10, float64(p.distanceDecimals))
+	return math.Round(miles*scale) / scale
+}
+
+/*
+formatDistance formats a distance for the table and tsv output
+
+Args:
+
+	miles: a distance in miles, as returned by roundDistance
+
+Returns:
+
+	the distance with the configured number of decimals, or such as "~1,200" when rounded to a step
+*/
+func (p *outputPrecision) formatDistance(miles float64) string {
+	if p == nil {
+		return fmt.Sprintf("%.2f", miles)
+	}
+	if p.distanceStep > 0 {
+		digits := strconv.FormatFloat(miles, 'f', 0, 64)
+		for i := len(digits) - 3; i > 0; i -= 3 {
+			digits = digits[:i] + "," + digits[i:]
+		}
+		return "~" + digits
+	}
+	return strconv.FormatFloat(miles, 'f', p.distanceDecimals, 64)
+}
+
+/*
+truncateLoc truncates the coordinates of a location to the configured number of decimals
+
+Args:
+
+	loc: a location in "lat,lon" format
+
+Returns:
+
+	the truncated location, or loc when no coordinate precision is configured or it has no location
+*/
+func (p *outputPrecision) truncateLoc(loc string) string {
+	if p == nil || p.coordDecimals < 0 || !hasLocation(loc) {
+		return loc
+	}
+	lat, lon := latlon2coord(loc)
+	scale := math.Pow(10, float64(p.coordDecimals))
+	lat, lon = math.Trunc(lat*scale)/scale, math.Trunc(lon*scale)/scale
+	return strconv.FormatFloat(lat, 'f', p.coordDecimals, 64) + "," + strconv.FormatFloat(lon, 'f', p.coordDecimals, 64)
+}
@@ -0,0 +1,86 @@
+/*
+
+provenance.go
+
+Support for -provenance, which annotates each field of the json and ndjson output with the source
+that provided it, so that consumers can weigh how much to trust each value:
+
+	{"input": {"value": "example.com", "source": "input"}, "ip": {"value": "93.184.215.14", "source": "dns"},
+	 "country": {"value": "US", "source": "ipinfo.io"}, ...}
+
+The sources are the providers listed by -describe-output.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// A resultRow that is encoded with the source of each field
+type provenanceRow resultRow
+
+// A single field of a provenanceRow
+type provenanceField struct {
+	Value  json.RawMessage `json:"value"`
+	Source string          `json:"source"`
+}
+
+// The JSON fields of resultRow in output order, with their sources
+var provenanceFields = describeFields(reflect.TypeOf(resultRow{}))
+
+func (row provenanceRow) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(resultRow(row))
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, field := range provenanceFields {
+		value, ok := values[field.Name] // fields omitted from the plain output are omitted here too
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.Name)
+		annotated, err := json.Marshal(provenanceField{Value: value, Source: strings.Join(field.Providers, "+")})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(annotated)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+/*
+provenanceRows converts rows so that they are encoded with the source of each field
+
+Args:
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+Returns:
+
+	a slice of provenanceRow, never nil
+*/
+func provenanceRows(rows []resultRow) []provenanceRow {
+	converted := make([]provenanceRow, len(rows))
+	for i, row := range rows {
+		converted[i] = provenanceRow(row)
+	}
+	return converted
+}
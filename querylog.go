@@ -0,0 +1,141 @@
+/*
+
+querylog.go
+
+Support for the -querylog option, which follows a resolver query log (BIND, unbound or dnsmasq)
+and continuously looks up the IP info of every newly seen name, similar to: tail -f
+Only queries logged after ipinfo starts are read, unless -querylog-from-start is given.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const queryLogPollInterval = time.Second
+
+// one expression per supported log format; the first group is the queried name
+var queryLogPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`query: (\S+) IN \S+`),           // BIND
+	regexp.MustCompile(`info: \S+ (\S+) \S+ IN\b`),      // unbound with log-queries or log-replies
+	regexp.MustCompile(`query\[[A-Z0-9]+\] (\S+) from`), // dnsmasq with log-queries
+}
+
+/*
+queryLogName extracts the queried name from a single log line
+
+Args:
+
+	line: a line from a resolver query log
+
+Returns:
+
+	the lower case name without a trailing dot, or an empty string when the line is not a query
+	or is a reverse lookup
+*/
+func queryLogName(line string) string {
+	for _, re := range queryLogPatterns {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(match[1], "."))
+		if strings.HasSuffix(name, ".arpa") {
+			return ""
+		}
+		return name
+	}
+	return ""
+}
+
+/*
+followQueryLog reads fname from its end, or from the beginning, and then waits for new lines, reopening
+the file when it is truncated or rotated; a new file is read from the beginning. This function does not
+return unless the file can not be read.
+
+Args:
+
+	fname: the query log file name
+
+	fromStart: also read the lines that are already in the file
+
+	names: each queried name is sent to this channel
+*/
+func followQueryLog(fname string, fromStart bool, names chan<- string) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	var offset int64
+	if !fromStart {
+		if offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	reader := bufio.NewReader(file)
+	partial := ""
+	for {
+		chunk, err := reader.ReadString('\n')
+		offset += int64(len(chunk))
+		partial += chunk
+		if err == nil {
+			if name := queryLogName(partial); len(name) > 0 {
+				names <- name
+			}
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		// wait for more data, then start over when the file was truncated or rotated
+		time.Sleep(queryLogPollInterval)
+		info, statErr := os.Stat(fname)
+		current, fileErr := file.Stat()
+		if statErr == nil && fileErr == nil && (info.Size() < offset || !os.SameFile(info, current)) {
+			file.Close()
+			if file, err = os.Open(fname); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			offset = 0
+			partial = ""
+		}
+	}
+}
+
+/*
+runQueryLog enriches every name found in a resolver query log, printing one line per IP address
+
+Args:
+
+	fname: the query log file name
+
+	fromStart: also enrich the queries that are already in the file
+
+	cache: the cache to use, or nil
+
+	feeds: the -feeds index, or nil
+
+Returns:
+
+	an error if the file can not be read
+*/
+func runQueryLog(fname string, fromStart bool, cache ipCache, feeds *feedIndex) error {
+	names := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- followQueryLog(fname, fromStart, names)
+	}()
+	return enrichStream(names, errCh, true, cache, feeds)
+}
@@ -0,0 +1,347 @@
+/*
+
+reconcile.go
+
+The reconcile subcommand compares an authoritative inventory of endpoints with their live lookups,
+for teams that keep the expected addresses and countries of their hosts in a CSV file:
+
+	ipinfo reconcile -expected expected.csv -actual live
+
+The CSV file has a header with a host column and optional ip and country columns; a host with several
+addresses is given on several lines. Instead of live lookups, -actual can read the output of -format json.
+Each host is reported as a match, a mismatch with the differences, or missing when it has no addresses.
+
+Exit status is 0 when all hosts match, 1 when any host is a mismatch or missing, and 2 on errors.
+
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	reconcileOk       = 0
+	reconcileMismatch = 1
+	reconcileError    = 2
+)
+
+// The expected addresses and country of a host
+type expectedHost struct {
+	ips     []string
+	country string
+}
+
+// The reconciliation of a single host
+type reconcileEntry struct {
+	Host            string   `json:"host"`
+	Status          string   `json:"status"` // match, mismatch or missing
+	ExpectedIPs     []string `json:"expected_ips,omitempty"`
+	ActualIPs       []string `json:"actual_ips,omitempty"`
+	ExpectedCountry string   `json:"expected_country,omitempty"`
+	ActualCountries []string `json:"actual_countries,omitempty"`
+	Problems        []string `json:"problems,omitempty"`
+}
+
+/*
+readExpected reads the expected inventory
+
+Args:
+
+	fname: a CSV file with a header naming a host column, and optional ip and country columns
+
+Returns:
+
+	the hosts in file order
+
+	a map with key=host
+*/
+func readExpected(fname string) ([]string, map[string]*expectedHost, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if len(records) < 2 {
+		return nil, nil, fmt.Errorf("%s: no hosts found", fname)
+	}
+
+	hostColumn, ipColumn, countryColumn := -1, -1, -1
+	for i, name := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "host", "hostname", "target":
+			hostColumn = i
+		case "ip", "address":
+			ipColumn = i
+		case "country":
+			countryColumn = i
+		}
+	}
+	if hostColumn < 0 {
+		return nil, nil, fmt.Errorf("%s: no host column in the header", fname)
+	}
+	cell := func(record []string, column int) string {
+		if column < 0 || column >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[column])
+	}
+
+	var order []string
+	hosts := make(map[string]*expectedHost)
+	for line, record := range records[1:] {
+		host := normalizeTarget(argHost(cell(record, hostColumn)))
+		if len(host) == 0 {
+			continue
+		}
+		expected, ok := hosts[host]
+		if !ok {
+			expected = &expectedHost{}
+			hosts[host] = expected
+			order = append(order, host)
+		}
+		for _, value := range strings.FieldsFunc(cell(record, ipColumn), func(r rune) bool { return r == ' ' || r == ';' }) {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: line %d: invalid IP address: %s", fname, line+2, value)
+			}
+			if ip := addr.Unmap().String(); !stringInSlice(ip, expected.ips) {
+				expected.ips = append(expected.ips, ip)
+			}
+		}
+		if country := cell(record, countryColumn); len(country) > 0 {
+			expected.country = strings.ToUpper(country)
+		}
+	}
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("%s: no hosts found", fname)
+	}
+	return order, hosts, nil
+}
+
+/*
+liveActual looks up the hosts
+
+Args:
+
+	hosts: the hosts to look up
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	a map with key=host, value=the IP info of each of its addresses; hosts that did not resolve are missing
+
+	a *rateLimitError when ipinfo.io refused further requests
+*/
+func liveActual(hosts []string, workers int, cache ipCache) (map[string][]ipInfoResult, error) {
+	replies, errors := resolveAllDNS(workers, hosts, false)
+	for _, err := range errors {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+	var ipAddrs []string
+	for _, reply := range replies {
+		for _, ip := range reply.addresses {
+			if !stringInSlice(ip, ipAddrs) {
+				ipAddrs = append(ipAddrs, ip)
+			}
+		}
+	}
+	ipInfo, err := resolveAllIpInfoFunc(workers, ipAddrs, cache, nil)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]ipInfoResult)
+	for _, info := range ipInfo {
+		infos[info.Ip] = info
+	}
+	actual := make(map[string][]ipInfoResult)
+	for _, reply := range replies {
+		for _, ip := range reply.addresses {
+			info := infos[ip]
+			info.Ip = ip
+			actual[reply.hostname] = append(actual[reply.hostname], info)
+		}
+	}
+	return actual, nil
+}
+
+/*
+fileActual reads earlier lookups from the output of -format json
+
+Args:
+
+	fname: the JSON file
+
+Returns:
+
+	a map with key=host, value=the IP info of each of its addresses
+*/
+func fileActual(fname string) (map[string][]ipInfoResult, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var rows []resultRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	actual := make(map[string][]ipInfoResult)
+	for _, row := range rows {
+		host := normalizeTarget(argHost(row.Input))
+		actual[host] = append(actual[host], row.ipInfoResult)
+	}
+	return actual, nil
+}
+
+/*
+reconcileHost compares the expected addresses and country of a host with its lookups
+
+Args:
+
+	host: the host name or IP address
+
+	expected: its expected addresses and country
+
+	actual: the IP info of each address it resolved to
+
+Returns:
+
+	a reconcileEntry struct
+*/
+func reconcileHost(host string, expected *expectedHost, actual []ipInfoResult) reconcileEntry {
+	entry := reconcileEntry{Host: host, Status: "match", ExpectedIPs: expected.ips, ExpectedCountry: expected.country}
+	if len(actual) == 0 {
+		entry.Status = "missing"
+		entry.Problems = []string{"no addresses found"}
+		return entry
+	}
+	for _, info := range actual {
+		ip := info.Ip
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			ip = addr.Unmap().String()
+		}
+		if !stringInSlice(ip, entry.ActualIPs) {
+			entry.ActualIPs = append(entry.ActualIPs, ip)
+		}
+		country := strings.ToUpper(info.Country)
+		if len(country) > 0 && !stringInSlice(country, entry.ActualCountries) {
+			entry.ActualCountries = append(entry.ActualCountries, country)
+		}
+		if len(expected.country) > 0 {
+			if len(country) == 0 {
+				entry.Problems = append(entry.Problems, fmt.Sprintf("the country of %s is unknown", ip))
+			} else if country != expected.country {
+				entry.Problems = append(entry.Problems, fmt.Sprintf("%s is in %s", ip, country))
+			}
+		}
+	}
+	if len(expected.ips) > 0 {
+		for _, ip := range expected.ips {
+			if !stringInSlice(ip, entry.ActualIPs) {
+				entry.Problems = append(entry.Problems, fmt.Sprintf("%s not found", ip))
+			}
+		}
+		for _, ip := range entry.ActualIPs {
+			if !stringInSlice(ip, expected.ips) {
+				entry.Problems = append(entry.Problems, fmt.Sprintf("%s unexpected", ip))
+			}
+		}
+	}
+	sort.Strings(entry.ActualIPs)
+	if len(entry.Problems) > 0 {
+		entry.Status = "mismatch"
+	}
+	return entry
+}
+
+/*
+runReconcile implements the reconcile subcommand
+
+Args:
+
+	args: the command line arguments following "reconcile"
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	the program's exit status
+*/
+func runReconcile(args []string, workers int, cache ipCache) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	expectedFlag := flags.String("expected", "", "CSV file with a host column and optional ip and country columns")
+	actualFlag := flags.String("actual", "live", "live to look up the hosts, or a file written by -format json")
+	jsonFlag := flags.Bool("json", false, "output JSON instead of a table")
+	if err := flags.Parse(args); err != nil {
+		return reconcileError
+	}
+	if len(*expectedFlag) == 0 {
+		fmt.Fprintln(os.Stderr, "reconcile needs an inventory given with -expected")
+		return reconcileError
+	}
+
+	hosts, expected, err := readExpected(*expectedFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return reconcileError
+	}
+	var actual map[string][]ipInfoResult
+	if *actualFlag == "live" {
+		actual, err = liveActual(hosts, workers, cache)
+	} else {
+		actual, err = fileActual(*actualFlag)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return reconcileError
+	}
+
+	entries := []reconcileEntry{}
+	counts := make(map[string]int)
+	for _, host := range hosts {
+		entry := reconcileHost(host, expected[host], actual[host])
+		entries = append(entries, entry)
+		counts[entry.Status]++
+	}
+
+	if *jsonFlag {
+		err = writeJSON(os.Stdout, entries)
+	} else {
+		table := newTable(os.Stdout)
+		table.SetHeader([]string{"Host", "Status", "Expected IP", "Actual IP", "Expected Country", "Actual Country", "Problems"})
+		table.SetAutoWrapText(false)
+		for _, entry := range entries {
+			table.Append([]string{entry.Host, entry.Status, orNA(strings.Join(entry.ExpectedIPs, ", ")), orNA(strings.Join(entry.ActualIPs, ", ")),
+				orNA(entry.ExpectedCountry), orNA(strings.Join(entry.ActualCountries, ", ")), strings.Join(entry.Problems, "; ")})
+		}
+		table.Render()
+		fmt.Printf("%d hosts: %d match, %d mismatch, %d missing\n", len(entries), counts["match"], counts["mismatch"], counts["missing"])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return reconcileError
+	}
+	if counts["match"] < len(entries) {
+		return reconcileMismatch
+	}
+	return reconcileOk
+}
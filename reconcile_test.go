@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReconcileRateLimited(t *testing.T) {
+	expected := filepath.Join(t.TempDir(), "expected.csv")
+	if err := os.WriteFile(expected, []byte("host,country\na.example,DE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{limited: true})
+	if status := runReconcile([]string{"-expected", expected}, 2, nil); status != reconcileError {
+		t.Errorf("got exit status %d, want %d", status, reconcileError)
+	}
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{})
+	if status := runReconcile([]string{"-expected", expected}, 2, nil); status != reconcileOk {
+		t.Errorf("got exit status %d, want %d", status, reconcileOk)
+	}
+}
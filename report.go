@@ -0,0 +1,67 @@
+/*
+
+report.go
+
+Support for -format html, which generates a standalone HTML report: a sortable table of the results
+and a map with a pin for each located IP address. The map is drawn with Leaflet and OpenStreetMap tiles,
+so it needs internet access when the report is viewed.
+
+*/
+
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+//go:embed data/report.html
+var reportHTML string
+
+// A map pin in the HTML report
+type reportPin struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Label string  `json:"label"`
+}
+
+/*
+outputHTML outputs the HTML report
+
+Args:
+
+	w: where to write the report to
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	columns: the data for optional columns; only columns with data are output
+
+	source: describes the local IP address that distances were computed from
+
+	generated: the time the report was generated, or an empty string to omit it
+*/
+func outputHTML(w io.Writer, rows []resultRow, columns extraColumns, source string, generated string) error {
+	tmpl, err := template.New("report").Parse(reportHTML)
+	if err != nil {
+		return err
+	}
+	header, cells := tableCells(rows, columns)
+	pins := []reportPin{}
+	for _, row := range rows {
+		if !hasLocation(row.Loc) {
+			continue
+		}
+		lat, lon := latlon2coord(row.Loc)
+		label := fmt.Sprintf("%s (%s) %s, %s, %s - %s", row.Input, row.Ip, row.City, row.Region, row.Country, row.Org)
+		pins = append(pins, reportPin{Lat: lat, Lon: lon, Label: label})
+	}
+	return tmpl.Execute(w, struct {
+		Generated string
+		Source    string
+		Header    []string
+		Rows      [][]string
+		Pins      []reportPin
+	}{generated, source, header, cells, pins})
+}
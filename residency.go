@@ -0,0 +1,167 @@
+/*
+
+residency.go
+
+The residency subcommand checks that all endpoints of a service are located within the allowed regions.
+It is intended for CI pipelines:
+
+	ipinfo residency -allowed EU -f endpoints.txt
+
+Exit status is 0 when all endpoints are allowed, 1 when any endpoint is outside of the allowed regions
+and 2 when an endpoint could not be checked.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	residencyOk      = 0
+	residencyOutside = 1
+	residencyError   = 2
+)
+
+/*
+allowedCountries converts a list of country groups and/or country codes into a set of country codes
+
+Args:
+
+	list: a comma separated list such as "EU,CH,GB"
+
+	groups: a map as returned by loadCountryGroups
+
+Returns:
+
+	a map where key=upper case country code
+*/
+func allowedCountries(list string, groups map[string][]string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if countries, ok := groups[strings.ToLower(name)]; ok {
+			for _, country := range countries {
+				allowed[country] = true
+			}
+			continue
+		}
+		if len(name) != 2 {
+			return nil, fmt.Errorf("unknown country group or code: %s", name)
+		}
+		allowed[strings.ToUpper(name)] = true
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("no allowed regions given")
+	}
+	return allowed, nil
+}
+
+/*
+runResidency implements the residency subcommand
+
+Args:
+
+	args: the command line arguments following "residency"
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	the program's exit status
+*/
+func runResidency(args []string, workers int, cache ipCache) int {
+	flags := flag.NewFlagSet("residency", flag.ContinueOnError)
+	allowedFlag := flags.String("allowed", "", "comma separated country groups and/or country codes, such as: EU,CH")
+	fileFlag := flags.String("f", "", "file containing one endpoint per line")
+	groupsFlag := flags.String("tag-groups", "", "file defining additional country groups")
+	if err := flags.Parse(args); err != nil {
+		return residencyError
+	}
+
+	groups, err := loadCountryGroups(*groupsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return residencyError
+	}
+	allowed, err := allowedCountries(*allowedFlag, groups)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return residencyError
+	}
+
+	targets := flags.Args()
+	if len(*fileFlag) > 0 {
+		fromFile, err := readTargetsFile(*fileFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return residencyError
+		}
+		targets = append(targets, fromFile...)
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "no endpoints given")
+		return residencyError
+	}
+
+	hostnames := normalizedTargets(targets)
+	replies, errors := resolveAllDNS(workers, hostnames, false)
+	var ipAddrs []string
+	for _, reply := range replies {
+		for _, ip := range reply.addresses {
+			if !stringInSlice(ip, ipAddrs) {
+				ipAddrs = append(ipAddrs, ip)
+			}
+		}
+	}
+	ipInfo, err := resolveAllIpInfoFunc(workers, ipAddrs, cache, nil)
+	if err != nil {
+		// a rate limit means the endpoints could not be checked, which must not look like a violation
+		fmt.Fprintln(os.Stderr, err)
+		return residencyError
+	}
+	countries := make(map[string]string)
+	for _, info := range ipInfo {
+		countries[info.Ip] = info.Country
+	}
+
+	status := residencyOk
+	var report []string
+	for _, err := range errors {
+		report = append(report, fmt.Sprintf("ERROR    %s", err))
+		status = residencyError
+	}
+	for _, reply := range replies {
+		for _, ip := range reply.addresses {
+			country := countries[ip]
+			if len(country) == 0 {
+				report = append(report, fmt.Sprintf("ERROR    %s (%s): country is unknown", reply.hostname, ip))
+				status = residencyError
+				continue
+			}
+			if !allowed[strings.ToUpper(country)] {
+				report = append(report, fmt.Sprintf("OUTSIDE  %s (%s): located in %s", reply.hostname, ip, country))
+				if status == residencyOk {
+					status = residencyOutside
+				}
+			}
+		}
+	}
+
+	sort.Strings(report)
+	for _, line := range report {
+		fmt.Println(line)
+	}
+	fmt.Printf("%d endpoints checked, %d addresses, %d problems\n", len(hostnames), len(ipAddrs), len(report))
+	return status
+}
@@ -0,0 +1,10 @@
+package main
+
+import "testing"
+
+func TestResidencyRateLimited(t *testing.T) {
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, fakeIpinfo{limited: true})
+	if status := runResidency([]string{"-allowed", "DE", "a.example"}, 2, nil); status != residencyError {
+		t.Errorf("got exit status %d, want %d", status, residencyError)
+	}
+}
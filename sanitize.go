@@ -0,0 +1,107 @@
+/*
+
+sanitize.go
+
+Hardening against broken or malicious answers of ipinfo.io and the other geolocation providers.
+Response bodies are read up to a maximum size, JSON answers must have the expected types, and the
+strings of each answer are stripped of ANSI escape sequences and other control characters before they
+reach the terminal, where they could otherwise move the cursor, rewrite earlier output or set the title.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// the largest response body read from a provider; an answer is usually well under 1 KB
+const maxResponseSize = 1024 * 1024
+
+// CSI sequences such as ESC [ 31 m, OSC sequences such as ESC ] 0 ; title BEL, and two character escapes
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\^_])`)
+
+/*
+readResponse reads the body of a provider response, up to maxResponseSize
+
+Args:
+
+	resp: the response; its body is not closed
+
+Returns:
+
+	the body, or an error when it is larger than maxResponseSize
+*/
+func readResponse(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%s: the response is larger than %d bytes", resp.Request.URL.Host, maxResponseSize)
+	}
+	return body, nil
+}
+
+/*
+decodeJSON decodes a single JSON value; unknown fields are ignored, but fields of the wrong type and
+data after the value are errors
+
+Args:
+
+	body: the JSON text
+
+	v: a pointer to the value to decode into
+
+Returns:
+
+	an error when body is not a single JSON value of the expected types
+*/
+func decodeJSON(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON: unexpected data after the value")
+	}
+	return nil
+}
+
+/*
+sanitizeText removes ANSI escape sequences, control characters and bidirectional overrides from a string
+given by a provider, and replaces invalid UTF-8
+
+Args:
+
+	s: the string to clean
+
+Returns:
+
+	a string that is safe to write to a terminal
+*/
+func sanitizeText(s string) string {
+	s = ansiEscape.ReplaceAllString(strings.ToValidUTF8(s, "\uFFFD"), "")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+/*
+sanitize cleans every string field of a provider answer with sanitizeText
+*/
+func (info *ipInfoResult) sanitize() {
+	for _, field := range []*string{&info.Ip, &info.Hostname, &info.City, &info.Region, &info.Country, &info.Loc, &info.Postal, &info.Org} {
+		*field = sanitizeText(*field)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+
+scan.go
+
+Support for -scan, which extracts every IPv4 address, IPv6 address and host name from free-form text,
+such as log snippets, email bodies or chat pastes, so that they can be looked up without preprocessing.
+Host names are only accepted when they end in a known public suffix, so that file names such as
+notes.txt and version numbers such as 1.2.3 are not mistaken for hosts. -paste scans the system clipboard
+the same way.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+/*
+isScanSeparator reports if r can not be part of an IP address or host name
+
+Args:
+
+	r: a character of the scanned text
+
+Returns:
+
+	true when r separates tokens
+*/
+func isScanSeparator(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case r == '.' || r == '-' || r == ':' || r == '_':
+		return false
+	}
+	return true
+}
+
+/*
+scanToken converts a token of the scanned text into a target
+
+Args:
+
+	token: a run of characters that can be part of an IP address or host name
+
+Returns:
+
+	the IP address or lower case host name, and false when the token is neither
+*/
+func scanToken(token string) (string, bool) {
+	token = strings.Trim(token, ".:-_")
+	if ip := net.ParseIP(token); ip != nil {
+		return ip.String(), true
+	}
+	if host, _, err := net.SplitHostPort(token); err == nil { // an address or host name with a port
+		token = host
+		if ip := net.ParseIP(token); ip != nil {
+			return ip.String(), true
+		}
+	}
+	if strings.ContainsAny(token, ":_") || !strings.Contains(token, ".") {
+		return "", false
+	}
+	host := strings.ToLower(token)
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", false
+		}
+	}
+	suffix, icann := publicsuffix.PublicSuffix(host)
+	if !icann || suffix == host || strings.Trim(suffix, "abcdefghijklmnopqrstuvwxyz.") != "" {
+		return "", false
+	}
+	return host, true
+}
+
+/*
+scanTargets extracts the IP addresses and host names from free-form text
+
+Args:
+
+	r: the text to scan
+
+Returns:
+
+	the IP addresses and host names, without duplicates, in the order they first appear
+*/
+func scanTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		for _, token := range strings.FieldsFunc(scanner.Text(), isScanSeparator) {
+			if target, ok := scanToken(token); ok && !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets, scanner.Err()
+}
+
+/*
+readClipboard reads the text of the system clipboard with the command line tool of each platform:
+pbpaste on macOS, PowerShell's Get-Clipboard on Windows, and wl-paste, xclip or xsel elsewhere
+
+Returns:
+
+	the text of the clipboard
+*/
+func readClipboard() (string, error) {
+	var commands [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		commands = [][]string{{"pbpaste"}}
+	case "windows":
+		commands = [][]string{{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "Get-Clipboard -Raw"}}
+	default:
+		commands = [][]string{{"wl-paste", "--no-newline"}, {"xclip", "-selection", "clipboard", "-out"}, {"xsel", "--clipboard", "--output"}}
+	}
+	var tried []string
+	for _, command := range commands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			tried = append(tried, command[0])
+			continue
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if detail := strings.TrimSpace(stderr.String()); err != nil && len(detail) > 0 {
+			return "", fmt.Errorf("reading the clipboard with %s: %v: %s", command[0], err, detail)
+		} else if err != nil {
+			return "", fmt.Errorf("reading the clipboard with %s: %w", command[0], err)
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("reading the clipboard needs one of: %s", strings.Join(tried, ", "))
+}
@@ -0,0 +1,422 @@
+/*
+
+serve.go
+
+The serve subcommand runs ipinfo as an HTTP enrichment service:
+
+	GET /lookup?q=example.com,1.2.3.4   the same results as -j, as a JSON array
+	GET /stream?q=example.com,1.2.3.4   the same results as -ndjson, output as each lookup completes
+	GET /                               a web page to look up a list of hosts, public even with -keys; see portal.go
+	GET /healthz                        200 while the process is running
+	GET /readyz                         200 once the service's own location is known
+	GET /usage                          the usage of the caller's API key, when -keys is given
+	GET /openapi.json                   the OpenAPI 3 document describing these endpoints
+	/grafana/                           a Grafana data source for -history; see grafana.go
+
+Responses are kept in memory for -memory-ttl and served stale for up to -stale while they are refreshed
+in the background; see memcache.go.
+
+On SIGTERM, /readyz fails for -drain so that load balancers stop sending requests, then in-flight lookups
+are given up to -shutdown-timeout to finish. On SIGHUP, the -keys file is read again so that API keys can
+be rotated without a restart.
+
+Every option can also be set with an environment variable, so that the service can be configured
+entirely from its container environment, such as in a Kubernetes pod spec.
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+envString returns the value of an environment variable, or fallback when it is not set
+
+Args:
+
+	name: the environment variable
+
+	fallback: the default value
+
+Returns:
+
+	the value to use as a flag's default
+*/
+func envString(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+/*
+envInt is the same as envString for integer values; invalid values are ignored
+*/
+func envInt(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+/*
+envDuration is the same as envString for duration values; invalid values are ignored
+*/
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// The state shared by all HTTP handlers
+type server struct {
+	workers    int
+	maxTargets int
+	cache      ipCache
+	loc        string
+	ready      atomic.Bool
+	draining   atomic.Bool   // set on SIGTERM, so that /readyz fails while in-flight lookups finish
+	stopped    chan struct{} // closed once a graceful shutdown is complete
+	keys       *keyring      // nil when API keys are not required
+	audit      *auditLog
+	history    historyStore              // nil when -history is not given
+	feeds      atomic.Pointer[feedIndex] // nil until -feeds are loaded
+}
+
+/*
+runServe implements the serve subcommand
+
+Args:
+
+	args: the command line arguments following "serve"
+
+	workers: the -t value given before the subcommand, used as the default
+
+	dsn: the -cache value given before the subcommand, used as the default
+
+	ttl: the -cache-ttl value given before the subcommand, used as the default
+
+	history: the -history value given before the subcommand, used as the default
+
+	feeds: the -feeds value given before the subcommand, used as the default
+
+Returns:
+
+	an error if the server could not be started
+*/
+func runServe(args []string, workers int, dsn string, ttl time.Duration, history string, feeds string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listenFlag := flags.String("listen", envString("IPINFO_LISTEN", ":8080"), "address to listen on; env: IPINFO_LISTEN")
+	workersFlag := flags.Int("t", envInt("IPINFO_THREADS", workers), "number of simultaneous threads per request; env: IPINFO_THREADS")
+	cacheFlag := flags.String("cache", envString("IPINFO_CACHE", dsn), "share looked up IP info through this cache; env: IPINFO_CACHE")
+	cacheTTLFlag := flags.Duration("cache-ttl", envDuration("IPINFO_CACHE_TTL", ttl), "how long cache entries are used before being revalidated; env: IPINFO_CACHE_TTL")
+	memoryTTLFlag := flags.Duration("memory-ttl", envDuration("IPINFO_MEMORY_TTL", time.Hour), "how long responses are kept in memory before being refreshed, 0 to disable; env: IPINFO_MEMORY_TTL")
+	staleFlag := flags.Duration("stale", envDuration("IPINFO_STALE", 24*time.Hour), "how long expired responses are still served while being refreshed in the background; env: IPINFO_STALE")
+	keysFlag := flags.String("keys", envString("IPINFO_KEYS", ""), "require an API key from this file for lookups; env: IPINFO_KEYS")
+	usageFlag := flags.String("usage", envString("IPINFO_USAGE", ""), "save the usage of each API key to this SQLite database; env: IPINFO_USAGE")
+	maxTargetsFlag := flags.Int("max-targets", envInt("IPINFO_MAX_TARGETS", 100), "maximum number of targets per request; env: IPINFO_MAX_TARGETS")
+	drainFlag := flags.Duration("drain", envDuration("IPINFO_DRAIN", 5*time.Second), "on SIGTERM, how long /readyz fails before new connections are refused; env: IPINFO_DRAIN")
+	shutdownTimeoutFlag := flags.Duration("shutdown-timeout", envDuration("IPINFO_SHUTDOWN_TIMEOUT", 30*time.Second), "on SIGTERM, how long in-flight lookups may take to finish; env: IPINFO_SHUTDOWN_TIMEOUT")
+	historyFlag := flags.String("history", envString("IPINFO_HISTORY", history), "serve the results of past runs in this history file or storage DSN to Grafana at /grafana/; env: IPINFO_HISTORY")
+	feedsFlag := flags.String("feeds", envString("IPINFO_FEEDS", feeds), "tag each result with the threat feeds that list it; env: IPINFO_FEEDS")
+	feedsRefreshFlag := flags.Duration("feeds-refresh", envDuration("IPINFO_FEEDS_REFRESH", 24*time.Hour), "how often the -feeds are downloaded again; env: IPINFO_FEEDS_REFRESH")
+	openAudit := addAuditFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cache, err := openCache(*cacheFlag, *cacheTTLFlag, false)
+	if err != nil {
+		return err
+	}
+	audit, err := openAudit()
+	if err != nil {
+		return err
+	}
+	var feedList []threatFeed
+	if len(*feedsFlag) > 0 {
+		if feedList, err = parseFeeds(*feedsFlag); err != nil {
+			return err
+		}
+	}
+	if *memoryTTLFlag > 0 {
+		memory := newMemoryCache(cache, *memoryTTLFlag, *staleFlag)
+		go memory.pruneEvery(*memoryTTLFlag)
+		cache = memory
+	}
+	srv := &server{workers: *workersFlag, maxTargets: *maxTargetsFlag, cache: cache, audit: audit, stopped: make(chan struct{})}
+	if len(*historyFlag) > 0 {
+		if srv.history, err = openHistory(*historyFlag); err != nil {
+			return err
+		}
+	}
+	go srv.locate()
+	if feedList != nil {
+		go refreshFeedsEvery(feedList, *feedsRefreshFlag, &srv.feeds)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", srv.handleHealthz)
+	mux.HandleFunc("/readyz", srv.handleReadyz)
+	if len(*keysFlag) > 0 {
+		if srv.keys, err = loadKeyring(*keysFlag, *usageFlag); err != nil {
+			return err
+		}
+		go srv.keys.saveEvery(time.Minute)
+		mux.HandleFunc("/lookup", srv.keys.requireKey(srv.handleLookup, 0))
+		mux.HandleFunc("/stream", srv.keys.requireKey(srv.handleStream, 0))
+		mux.HandleFunc("/usage", srv.keys.requireKey(srv.handleUsage, 1))
+		if srv.history != nil {
+			mux.HandleFunc("/grafana/", srv.keys.requireKey(srv.handleGrafana, 1))
+		}
+	} else {
+		mux.HandleFunc("/lookup", func(w http.ResponseWriter, r *http.Request) {
+			srv.handleLookup(w, r, "")
+		})
+		mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+			srv.handleStream(w, r, "")
+		})
+		if srv.history != nil {
+			mux.HandleFunc("/grafana/", func(w http.ResponseWriter, r *http.Request) {
+				srv.handleGrafana(w, r, "")
+			})
+		}
+	}
+	mux.HandleFunc("/", srv.handlePortal)
+
+	spec := openAPISpec(srv.keys != nil)
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, spec)
+	})
+
+	httpServer := &http.Server{Addr: *listenFlag, Handler: mux}
+	go srv.handleSignals(httpServer, *drainFlag, *shutdownTimeoutFlag)
+
+	fmt.Fprintln(os.Stderr, "listening on", *listenFlag)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	<-srv.stopped
+	return nil
+}
+
+/*
+handleSignals reloads the API keys on SIGHUP and shuts down gracefully on SIGTERM or SIGINT:
+/readyz fails first so that load balancers stop sending new requests, then the listener is
+closed and in-flight lookups are allowed to finish
+
+Args:
+
+	httpServer: the server to shut down
+
+	drain: how long /readyz fails before the listener is closed
+
+	timeout: how long in-flight requests may take to finish after the listener is closed
+*/
+func (srv *server) handleSignals(httpServer *http.Server, drain time.Duration, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if srv.keys == nil {
+				continue
+			}
+			if err := srv.keys.reload(); err != nil {
+				fmt.Fprintln(os.Stderr, "reload error:", err)
+			} else {
+				fmt.Fprintln(os.Stderr, "reloaded", srv.keys.keysFile)
+			}
+			continue
+		}
+
+		fmt.Fprintln(os.Stderr, "shutting down")
+		signal.Stop(signals)
+		srv.draining.Store(true)
+		time.Sleep(drain)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := httpServer.Shutdown(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "shutdown error:", err)
+		}
+		cancel()
+		if srv.keys != nil {
+			if err := srv.keys.save(); err != nil {
+				fmt.Fprintln(os.Stderr, "usage error:", err)
+			}
+		}
+		close(srv.stopped)
+		return
+	}
+}
+
+/*
+locate looks up the service's own location, which is used for the distance of each result,
+retrying until it succeeds. The service is ready after this.
+*/
+func (srv *server) locate() {
+	for {
+		local := callRemoteService("")
+		if len(local.Ip) > 0 {
+			srv.loc = local.Loc
+			srv.ready.Store(true)
+			return
+		}
+		wait := 10 * time.Second
+		var limited *rateLimitError
+		if errors.As(local.ErrMsg, &limited) && limited.retryAfter > wait {
+			wait = limited.retryAfter
+		}
+		if local.ErrMsg != nil {
+			fmt.Fprintf(os.Stderr, "locate error: %v; retrying in %s\n", local.ErrMsg, wait)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (srv *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (srv *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if srv.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !srv.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (srv *server) handleUsage(w http.ResponseWriter, r *http.Request, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, srv.keys.report(name))
+}
+
+/*
+requestTargets returns the targets of a lookup request, given as one or more comma separated q parameters;
+when the request can not be served, an error is replied and false is returned
+
+Args:
+
+	w: the response
+
+	r: the request
+
+Returns:
+
+	the targets
+
+	false when an error was replied
+*/
+func (srv *server) requestTargets(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !srv.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	var targets []string
+	for _, q := range r.URL.Query()["q"] {
+		for _, target := range strings.Split(q, ",") {
+			if target = strings.TrimSpace(target); len(target) > 0 {
+				targets = append(targets, target)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		http.Error(w, "missing q parameter", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(targets) > srv.maxTargets {
+		http.Error(w, fmt.Sprintf("at most %d targets can be looked up at once", srv.maxTargets), http.StatusBadRequest)
+		return nil, false
+	}
+	return targets, true
+}
+
+/*
+replyLookupError replies 503 when ipinfo.io refused the lookups of a request because of its rate limit or
+the quotas of -tokens, or 502 when every lookup failed upstream; Retry-After tells clients when to try again
+
+Args:
+
+	w: the response
+
+	ipInfo: the results of the lookups
+
+	err: the error returned by resolveAllIpInfoFunc
+
+Returns:
+
+	true when an error was replied
+*/
+func replyLookupError(w http.ResponseWriter, ipInfo []ipInfoResult, err error) bool {
+	status := http.StatusServiceUnavailable
+	retryAfter := time.Minute
+	var limited *rateLimitError
+	switch {
+	case errors.As(err, &limited):
+		if limited.retryAfter > 0 {
+			retryAfter = limited.retryAfter
+		}
+	case len(ipInfo) > 0:
+		for _, info := range ipInfo {
+			if info.ErrMsg == nil {
+				return false
+			}
+		}
+		status, err, retryAfter = http.StatusBadGateway, ipInfo[0].ErrMsg, 10*time.Second
+	default:
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "lookup failed: "+err.Error(), status)
+	return true
+}
+
+// handleLookup implements /lookup; name is the team of the API key, or empty when keys are not required
+func (srv *server) handleLookup(w http.ResponseWriter, r *http.Request, name string) {
+	targets, ok := srv.requestTargets(w, r)
+	if !ok {
+		return
+	}
+
+	ipAddrs, reverseIP, _ := runDNS(srv.workers, normalizedTargets(targets), false)
+	if srv.keys != nil && !srv.keys.allowLookups(w, name, len(ipAddrs)) {
+		return
+	}
+	cached := srv.audit.cachedBefore(srv.cache, ipAddrs)
+	ipInfo, err := resolveAllIpInfoFunc(srv.workers, ipAddrs, srv.cache, nil)
+	if replyLookupError(w, ipInfo, err) {
+		return
+	}
+	if srv.keys != nil {
+		srv.keys.countLookups(name, len(ipAddrs))
+	}
+	srv.audit.record("serve", name, r.RemoteAddr, targets, ipAddrs, cached)
+	rows := buildRows(ipInfo, reverseIP, srv.loc, extraColumns{feeds: srv.feeds.Load()})
+	if rows == nil {
+		rows = []resultRow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, rows)
+}
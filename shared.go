@@ -0,0 +1,116 @@
+/*
+
+shared.go
+
+Support for -shared, which reports groups of inputs that resolve to the same IP address,
+the same network or the same AS, revealing shared infrastructure behind unrelated looking names.
+
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+/*
+networkOf returns the network an IP address belongs to
+
+Args:
+
+	ip: an IP address
+
+	v4bits: the prefix length of IPv4 networks, such as 24
+
+	v6bits: the prefix length of IPv6 networks, such as 48
+
+Returns:
+
+	the network in CIDR notation, or an empty string for an invalid IP address
+*/
+func networkOf(ip string, v4bits, v6bits int) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	mask := net.CIDRMask(v6bits, 128)
+	if addr.To4() != nil {
+		addr, mask = addr.To4(), net.CIDRMask(v4bits, 32)
+	}
+	network := net.IPNet{IP: addr.Mask(mask), Mask: mask}
+	return network.String()
+}
+
+/*
+sharedInfrastructure finds the IP addresses, networks and ASes that are used by more than one input
+
+Args:
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+Returns:
+
+	a slice of rows in this format: shared by, value, inputs
+*/
+func sharedInfrastructure(rows []resultRow) [][]string {
+	kinds := []struct {
+		name  string
+		value func(resultRow) string
+	}{
+		{"IP", func(row resultRow) string { return row.Ip }},
+		{"Network", func(row resultRow) string { return networkOf(row.Ip, 24, 48) }},
+		{"ASN", func(row resultRow) string { return row.Org }},
+	}
+
+	var allRows [][]string
+	for _, kind := range kinds {
+		inputs := make(map[string][]string)
+		for _, row := range rows {
+			value := kind.value(row)
+			if len(value) == 0 || stringInSlice(row.Input, inputs[value]) {
+				continue
+			}
+			inputs[value] = append(inputs[value], row.Input)
+		}
+		var values []string
+		for value, names := range inputs {
+			if len(names) > 1 {
+				values = append(values, value)
+			}
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			names := inputs[value]
+			sort.Strings(names)
+			allRows = append(allRows, []string{kind.name, value, strings.Join(names, ", ")})
+		}
+	}
+	return allRows
+}
+
+/*
+outputShared outputs a table with the infrastructure shared by more than one input
+
+Args:
+
+	w: where to write the table to
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	wrap: wrap output to better fit the screen width
+*/
+func outputShared(w io.Writer, rows []resultRow, wrap bool) {
+	allRows := sharedInfrastructure(rows)
+	if len(allRows) == 0 {
+		allRows = append(allRows, []string{"", "", "no shared infrastructure found"})
+	}
+	table := newTable(w)
+	table.SetHeader([]string{"Shared By", "Value", "Inputs"})
+	table.SetAutoWrapText(wrap)
+	table.SetAutoMergeCellsByColumnIndex([]int{0})
+	table.AppendBulk(allRows)
+	table.Render()
+}
@@ -0,0 +1,202 @@
+/*
+
+sitemap.go
+
+Support for -sitemap, which reads an XML sitemap, a sitemap index or a plain list of URLs, from a URL or
+a file, and looks up every host referenced in it, to map out where a site and its assets are served from.
+Sitemap indexes are followed, gzip compressed sitemaps are decompressed, and the hosts of image, video
+and alternate language URLs are included.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// the largest number of sitemaps read through sitemap indexes
+const maxSitemaps = 100
+
+// the largest sitemap read, after decompression; the protocol allows 50 MB
+const maxSitemapSize = 64 * 1024 * 1024
+
+/*
+openSitemap opens a sitemap by URL or file name, decompressing it when it is gzip compressed
+
+Args:
+
+	location: an http or https URL, or a file name
+
+Returns:
+
+	the content of the sitemap
+*/
+func openSitemap(location string) ([]byte, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", location, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		r = file
+	}
+	defer r.Close()
+	buffered := bufio.NewReader(r)
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
+		}
+		reader = gz
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxSitemapSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	return data, nil
+}
+
+/*
+parseSitemap extracts the URLs of a sitemap
+
+Args:
+
+	data: an XML sitemap or sitemap index, or a text file with one URL per line
+
+Returns:
+
+	the URLs of pages and assets
+
+	the URLs of the sitemaps listed in a sitemap index
+*/
+func parseSitemap(data []byte) ([]string, []string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		var urls []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); strings.Contains(line, "://") {
+				urls = append(urls, line)
+			}
+		}
+		return urls, nil, nil
+	}
+
+	var urls, sitemaps []string
+	inSitemap := false // inside a <sitemap> element of a sitemap index
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch name := element.Name.Local; {
+			case name == "sitemap":
+				inSitemap = true
+			case name == "link": // <xhtml:link rel="alternate" href="..."/>
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "href" {
+						urls = append(urls, strings.TrimSpace(attr.Value))
+					}
+				}
+			case name == "loc" || strings.HasSuffix(name, "_loc"): // loc, image:loc, video:content_loc and video:player_loc
+				var value string
+				if err := decoder.DecodeElement(&value, &element); err != nil {
+					return nil, nil, err
+				}
+				if inSitemap {
+					sitemaps = append(sitemaps, strings.TrimSpace(value))
+				} else {
+					urls = append(urls, strings.TrimSpace(value))
+				}
+			}
+		case xml.EndElement:
+			if element.Name.Local == "sitemap" {
+				inSitemap = false
+			}
+		}
+	}
+	return urls, sitemaps, nil
+}
+
+/*
+readSitemap extracts the distinct hosts of a sitemap, following sitemap indexes
+
+Args:
+
+	location: the URL or file name of the sitemap
+
+Returns:
+
+	a slice of host names and IP addresses, in the order they first appear
+*/
+func readSitemap(location string) ([]string, error) {
+	var hosts []string
+	seen := make(map[string]bool)
+	queue := []string{location}
+	for read := 0; len(queue) > 0; read++ {
+		if read == maxSitemaps {
+			fmt.Fprintf(os.Stderr, "warning: %s: only the first %d sitemaps were read\n", location, maxSitemaps)
+			break
+		}
+		current := queue[0]
+		queue = queue[1:]
+		data, err := openSitemap(current)
+		if err != nil {
+			if current == location {
+				return nil, err
+			}
+			fmt.Fprintln(os.Stderr, "warning:", err)
+			continue
+		}
+		urls, sitemaps, err := parseSitemap(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", current, err)
+		}
+		for _, sitemap := range sitemaps {
+			if !seen["sitemap "+sitemap] {
+				seen["sitemap "+sitemap] = true
+				queue = append(queue, sitemap)
+			}
+		}
+		for _, u := range urls {
+			if !strings.Contains(u, "://") {
+				continue
+			}
+			host := strings.ToLower(argHost(u))
+			if len(host) > 0 && !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s: no URLs found", location)
+	}
+	return hosts, nil
+}
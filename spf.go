@@ -0,0 +1,241 @@
+/*
+
+spf.go
+
+Support for -spf, which recursively expands the SPF record of a domain into the networks and addresses
+it allows to send mail, and geolocates them. include: and redirect= are followed; ip4:, ip6:, a and mx
+give the addresses. exists: and ptr depend on the sending server and can not be expanded.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SPF limits the number of DNS lookups to 10 (RFC 7208); this allows for records that exceed it
+const maxSPFDepth = 20
+
+// An address or network allowed by an SPF record
+type spfEntry struct {
+	domain    string // the domain whose record contains the mechanism
+	mechanism string
+	network   string // an IP address, or a network in CIDR notation
+}
+
+/*
+spfRecord finds the SPF record of a domain
+
+Args:
+
+	domain: the domain name
+
+Returns:
+
+	the record, starting with v=spf1
+*/
+func spfRecord(domain string) (string, error) {
+	records, err := txtRecords(domain)
+	if err != nil {
+		return "", err
+	}
+	for _, txt := range records {
+		if strings.HasPrefix(strings.ToLower(txt), "v=spf1 ") || strings.EqualFold(txt, "v=spf1") {
+			return txt, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no SPF record found", domain)
+}
+
+/*
+withCIDR appends the prefix length of an a or mx mechanism to an address
+
+Args:
+
+	ip: an IP address
+
+	v4cidr: such as /24, or empty
+
+	v6cidr: such as /64, or empty
+
+Returns:
+
+	the address, or the network it is in
+*/
+func withCIDR(ip string, v4cidr string, v6cidr string) string {
+	cidr := v6cidr
+	if net.ParseIP(ip).To4() != nil {
+		cidr = v4cidr
+	}
+	if len(cidr) == 0 {
+		return ip
+	}
+	if _, network, err := net.ParseCIDR(ip + cidr); err == nil {
+		return network.String()
+	}
+	return ip
+}
+
+/*
+expandSPF expands the SPF record of a domain into the networks it allows
+
+Args:
+
+	domain: the domain name
+
+	depth: the number of includes followed so far
+
+	visited: the domains already expanded, which also stops include loops
+
+Returns:
+
+	a slice of spfEntry structs, in record order
+
+	warnings for mechanisms that could not be expanded
+*/
+func expandSPF(domain string, depth int, visited map[string]bool) ([]spfEntry, []string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if visited[domain] {
+		return nil, []string{fmt.Sprintf("%s: already expanded, the include is skipped", domain)}
+	}
+	if depth > maxSPFDepth {
+		return nil, []string{fmt.Sprintf("%s: more than %d nested includes", domain, maxSPFDepth)}
+	}
+	visited[domain] = true
+	record, err := spfRecord(domain)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	var entries []spfEntry
+	var warnings []string
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		mechanism := strings.TrimLeft(term, "+-~?")
+		if strings.HasPrefix(term, "-") { // addresses that are not allowed to send
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(mechanism), "redirect=") {
+			redirect = mechanism[len("redirect="):]
+			continue
+		}
+		name, rest := mechanism, ""
+		if index := strings.IndexAny(mechanism, ":/"); index >= 0 {
+			name, rest = mechanism[:index], mechanism[index:]
+		}
+		value := strings.TrimPrefix(rest, ":")
+		switch strings.ToLower(name) {
+		case "a", "mx":
+			// a[:domain][/cidr4][//cidr6]
+			cidrs := ""
+			if index := strings.Index(rest, "/"); index >= 0 {
+				rest, cidrs = rest[:index], rest[index:]
+			}
+			target := domain
+			if strings.HasPrefix(rest, ":") {
+				target = rest[1:]
+			}
+			v4cidr, v6cidr, _ := strings.Cut(cidrs, "//")
+			if len(v6cidr) > 0 {
+				v6cidr = "/" + v6cidr
+			}
+			hosts := []string{target}
+			if strings.EqualFold(name, "mx") {
+				answers, err := queryDNS(target, dns.TypeMX)
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("%s: %s: %v", domain, term, err))
+					continue
+				}
+				hosts = nil
+				for _, rr := range answers {
+					if mx, ok := rr.(*dns.MX); ok {
+						hosts = append(hosts, mx.Mx)
+					}
+				}
+			}
+			for _, host := range hosts {
+				addresses, _, err := lookupHostTTL(strings.TrimSuffix(host, "."))
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("%s: %s: %v", domain, term, err))
+					continue
+				}
+				for _, ip := range addresses {
+					entries = append(entries, spfEntry{domain, term, withCIDR(ip, v4cidr, v6cidr)})
+				}
+			}
+		case "ip4", "ip6":
+			entries = append(entries, spfEntry{domain, term, value})
+		case "include":
+			included, more := expandSPF(value, depth+1, visited)
+			entries = append(entries, included...)
+			warnings = append(warnings, more...)
+		case "exists", "ptr":
+			warnings = append(warnings, fmt.Sprintf("%s: %s depends on the sending server and is not expanded", domain, term))
+		}
+	}
+	if len(redirect) > 0 {
+		redirected, more := expandSPF(redirect, depth+1, visited)
+		entries = append(entries, redirected...)
+		warnings = append(warnings, more...)
+	}
+	return entries, warnings
+}
+
+/*
+runSPF geolocates the networks allowed to send mail for a domain
+
+Args:
+
+	domain: the domain name
+
+	workers: the number of concurrent go routines to execute
+
+	cache: the cache to use, or nil
+
+	wrap: wrap output to better fit the screen width
+
+Returns:
+
+	an error if the SPF record allows no networks
+*/
+func runSPF(domain string, workers int, cache ipCache, wrap bool) error {
+	entries, warnings := expandSPF(domain, 0, make(map[string]bool))
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s: the SPF record allows no networks", domain)
+	}
+
+	// a network is located by its first address
+	var ipAddrs []string
+	for _, entry := range entries {
+		ip := strings.Split(entry.network, "/")[0]
+		if net.ParseIP(ip) != nil && !stringInSlice(ip, ipAddrs) {
+			ipAddrs = append(ipAddrs, ip)
+		}
+	}
+	infos := make(map[string]ipInfoResult)
+	for _, info := range resolveAllIpInfo(workers, ipAddrs, cache) {
+		infos[info.Ip] = info
+	}
+
+	var allRows [][]string
+	for _, entry := range entries {
+		info := infos[strings.Split(entry.network, "/")[0]]
+		allRows = append(allRows, []string{entry.domain, entry.mechanism, entry.network, orNA(info.Org), orNA(info.City), orNA(info.Country)})
+	}
+	table := newTable(os.Stdout)
+	table.SetHeader([]string{"Domain", "Mechanism", "Network", "Org", "City", "Country"})
+	table.SetAutoWrapText(wrap)
+	table.SetAutoMergeCellsByColumnIndex([]int{0})
+	table.AppendBulk(allRows)
+	table.Render()
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+
+sqlitestore.go
+
+The SQLite storage backend of the cache, the history and the target sets, selected with a sqlite:// DSN
+such as sqlite:///var/lib/ipinfo/ipinfo.db, or sqlite://ipinfo.db for a path relative to the current
+directory. The database file is created when missing, along with the tables of this file. SQLite is
+built in with the pure Go modernc.org/sqlite driver, so that no C compiler is needed.
+
+*/
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const createSQLiteTables string = `
+CREATE TABLE IF NOT EXISTS history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	record TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ip_cache (
+	ip TEXT PRIMARY KEY,
+	entry TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS target_sets (
+	name TEXT PRIMARY KEY,
+	targets TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ip_cache_expires_at ON ip_cache (expires_at);`
+
+/*
+connectSQLite opens a SQLite database, creating it and its tables when needed
+
+Args:
+
+	dsn: a URL such as sqlite:///var/lib/ipinfo/ipinfo.db
+
+Returns:
+
+	the database
+*/
+func connectSQLite(dsn string) (*sql.DB, error) {
+	fname := dsn[len(storageScheme(dsn))+len("://"):]
+	if len(fname) == 0 {
+		return nil, fmt.Errorf("missing database file name: %s", dsn)
+	}
+	db, err := sql.Open("sqlite", fname)
+	if err != nil {
+		return nil, err
+	}
+	// a single connection, so that concurrent writers wait for each other instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(createSQLiteTables); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return db, nil
+}
+
+// A history stored in the history table
+type sqliteHistory struct {
+	db *sql.DB
+}
+
+func (h sqliteHistory) load() ([]historyRecord, error) {
+	rows, err := h.db.Query("SELECT id, record FROM history ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []historyRecord
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var record historyRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("history[%d]: %w", id, err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (h sqliteHistory) append(records []historyRecord) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO history (record) VALUES (?)", string(data)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// A cache stored in the ip_cache table
+type sqliteCache struct {
+	db           *sql.DB
+	ttl          time.Duration
+	forceRefresh bool
+}
+
+/*
+newSQLiteCache opens a SQLite database and removes the entries that are too old to be revalidated
+
+Args:
+
+	dsn: a URL such as sqlite:///var/lib/ipinfo/ipinfo.db
+
+	ttl: how long entries are used before being revalidated
+
+	refresh: revalidate entries even when they have not expired
+
+Returns:
+
+	a sqliteCache
+*/
+func newSQLiteCache(dsn string, ttl time.Duration, refresh bool) (*sqliteCache, error) {
+	db, err := connectSQLite(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("DELETE FROM ip_cache WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteCache{db: db, ttl: ttl, forceRefresh: refresh}, nil
+}
+
+func (c *sqliteCache) get(ip string) (cacheEntry, bool) {
+	var entry cacheEntry
+	var data string
+	err := c.db.QueryRow("SELECT entry FROM ip_cache WHERE ip = ? AND expires_at >= ?", ip, time.Now().UTC()).Scan(&data)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal([]byte(data), &entry); err != nil || len(entry.Info.Ip) == 0 {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *sqliteCache) set(ip string, entry cacheEntry) error {
+	entry.Expires = time.Now().Add(c.ttl)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT INTO ip_cache (ip, entry, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (ip) DO UPDATE SET entry = excluded.entry, expires_at = excluded.expires_at`,
+		ip, string(data), entry.Expires.Add(cacheStaleRetention).UTC())
+	return err
+}
+
+func (c *sqliteCache) refresh() bool {
+	return c.forceRefresh
+}
+
+// Target sets stored in the target_sets table
+type sqliteTargetSets struct {
+	db *sql.DB
+}
+
+func (s sqliteTargetSets) load() (map[string][]string, error) {
+	rows, err := s.db.Query("SELECT name, targets FROM target_sets")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sets := make(map[string][]string)
+	for rows.Next() {
+		var name, data string
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, err
+		}
+		var targets []string
+		if err := json.Unmarshal([]byte(data), &targets); err != nil {
+			return nil, fmt.Errorf("target_sets[%s]: %w", name, err)
+		}
+		sets[name] = targets
+	}
+	return sets, rows.Err()
+}
+
+func (s sqliteTargetSets) set(name string, targets []string) error {
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO target_sets (name, targets) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET targets = excluded.targets`, name, string(data))
+	return err
+}
+
+func (s sqliteTargetSets) remove(name string) (bool, error) {
+	result, err := s.db.Exec("DELETE FROM target_sets WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
@@ -0,0 +1,118 @@
+/*
+
+stability.go
+
+Support for the -stability option, which resolves each host name several times and reports how many
+distinct IP addresses and locations were returned. This quantifies DNS round-robin and geo-balancing.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+probeStability resolves each host name count times, waiting interval between rounds
+
+Args:
+
+	hostnames: a slice containing the hostnames to look up; IP addresses are skipped
+
+	count: the number of times to resolve each host name
+
+	interval: the time to wait between rounds
+
+Returns:
+
+	a map where key=hostname, value=a slice of every distinct IP address that was returned
+*/
+func probeStability(hostnames []string, count int, interval time.Duration) map[string][]string {
+	seen := make(map[string][]string)
+	for round := 0; round < count; round++ {
+		if round > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		for _, hostname := range hostnames {
+			if net.ParseIP(hostname) != nil {
+				continue
+			}
+			addresses, err := lookupHost(hostname)
+			if err != nil {
+				continue
+			}
+			for _, ip := range addresses {
+				if !stringInSlice(ip, seen[hostname]) {
+					seen[hostname] = append(seen[hostname], ip)
+				}
+			}
+		}
+	}
+	return seen
+}
+
+/*
+outputStability outputs a secondary table with the number of distinct IP addresses and locations of each host name
+
+Args:
+
+	w: where to write the table to
+
+	seen: a map as returned by probeStability
+
+	count: the number of times each host name was resolved
+
+	workers: the number of concurrent go routines used to look up IP info
+
+	cache: the cache to use, or nil
+
+	ipInfo: a slice of ipInfoResult structs that are already known; other addresses are looked up
+
+	wrap: if -w was passed in as a command line parameter
+*/
+func outputStability(w io.Writer, seen map[string][]string, count int, workers int, cache ipCache, ipInfo []ipInfoResult, wrap bool) {
+	locations := make(map[string]string)
+	for _, info := range ipInfo {
+		locations[info.Ip] = fmt.Sprintf("%s, %s, %s", info.City, info.Region, info.Country)
+	}
+	var missing []string
+	for _, addresses := range seen {
+		for _, ip := range addresses {
+			if _, ok := locations[ip]; !ok && !stringInSlice(ip, missing) {
+				missing = append(missing, ip)
+			}
+		}
+	}
+	for _, info := range resolveAllIpInfo(workers, missing, cache) {
+		locations[info.Ip] = fmt.Sprintf("%s, %s, %s", info.City, info.Region, info.Country)
+	}
+
+	var allRows [][]string
+	for hostname, addresses := range seen {
+		var distinct []string
+		for _, ip := range addresses {
+			if !stringInSlice(locations[ip], distinct) {
+				distinct = append(distinct, locations[ip])
+			}
+		}
+		sort.Strings(addresses)
+		row := []string{hostname, strconv.Itoa(count), strconv.Itoa(len(addresses)), strconv.Itoa(len(distinct)), strings.Join(addresses, " ")}
+		allRows = append(allRows, row)
+	}
+	sort.Slice(allRows, func(a, b int) bool {
+		return allRows[a][0] < allRows[b][0]
+	})
+
+	table := newTable(w)
+	table.SetHeader([]string{"Input", "Queries", "Distinct IPs", "Distinct Locations", "IPs"})
+	table.SetAutoWrapText(wrap)
+	table.AppendBulk(allRows)
+	table.Render()
+}
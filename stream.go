@@ -0,0 +1,245 @@
+/*
+
+stream.go
+
+Continuous enrichment of targets that arrive over time, such as from a query log or a named pipe.
+Results are printed as soon as each target is looked up, one tab separated line per IP address.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// how many names and IP addresses enrichStream remembers; the least recently used are forgotten first
+const streamMemorySize = 100000
+
+// how long enrichStream waits when ipinfo.io rate limits it without saying for how long
+const streamRetryDelay = time.Minute
+
+// A map with a maximum size that forgets the least recently used keys, so that streams running for
+// months do not grow without limit
+type lruCache struct {
+	size    int
+	order   *list.List // the front is the most recently used key
+	entries map[string]*list.Element
+}
+
+// An entry of an lruCache
+type lruEntry struct {
+	key  string
+	info ipInfoResult
+}
+
+/*
+newLRUCache creates an empty lruCache
+
+Args:
+
+	size: the maximum number of keys
+
+Returns:
+
+	a pointer to an lruCache struct
+*/
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the value of a key, marking it as recently used
+func (c *lruCache) get(key string) (ipInfoResult, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return ipInfoResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).info, true
+}
+
+// add sets the value of a key, forgetting the least recently used key when the cache is full
+func (c *lruCache) add(key string, info ipInfoResult) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry).info = info
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, info: info})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+/*
+lookupStreamIpInfo looks up an IP address for enrichStream; while ipinfo.io refuses further requests, it waits
+for the Retry-After time and tries again, so that a stream running for months survives a rate limit
+
+Args:
+
+	ip: an IP address
+
+	cache: the cache to use, or nil
+
+Returns:
+
+	an ipInfoResult struct; ErrMsg is set when the lookup failed for another reason
+*/
+func lookupStreamIpInfo(ip string, cache ipCache) ipInfoResult {
+	for {
+		info := lookupIpInfo(ip, cache)
+		var limited *rateLimitError
+		if !errors.As(info.ErrMsg, &limited) {
+			return info
+		}
+		wait := limited.retryAfter
+		if wait <= 0 {
+			wait = streamRetryDelay
+		}
+		fmt.Fprintf(os.Stderr, "%v; retrying in %v\n", info.ErrMsg, wait)
+		time.Sleep(wait)
+	}
+}
+
+/*
+enrichStream looks up each target received from names until an error is received from errCh
+IP info is kept in memory, so a target seen again does not cost another API call; only the most recently
+used streamMemorySize targets and IP addresses are kept
+
+Args:
+
+	names: targets to look up; these can be any of the following: URL, email, hostname, IP address
+
+	errCh: receives the error that ends the stream
+
+	once: when true, a target that was already printed is skipped
+
+	cache: the cache to use, or nil
+
+	feeds: the -feeds index, or nil; the feeds that list an IP address are added as the last field
+
+Returns:
+
+	the error received from errCh
+*/
+func enrichStream(names <-chan string, errCh <-chan error, once bool, cache ipCache, feeds *feedIndex) error {
+	seen := newLRUCache(streamMemorySize)
+	known := newLRUCache(streamMemorySize)
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case name := <-names:
+			if _, ok := seen.get(name); once && ok {
+				continue
+			}
+			seen.add(name, ipInfoResult{})
+			hostname := truncateArgParts([]string{name})[0]
+			addresses, err := lookupHost(hostname)
+			if err != nil {
+				fmt.Printf("%s\terror: %v\n", hostname, err)
+				continue
+			}
+			for _, ip := range addresses {
+				info, ok := known.get(ip)
+				if !ok {
+					info = lookupStreamIpInfo(ip, cache)
+					if info.ErrMsg != nil {
+						fmt.Printf("%s\t%s\terror: %v\n", hostname, ip, info.ErrMsg)
+						continue
+					}
+					if len(info.Ip) > 0 {
+						known.add(ip, info)
+					}
+				}
+				line := fmt.Sprintf("%s\t%s\t%s\t%s, %s, %s", hostname, ip, info.Org, info.City, info.Region, info.Country)
+				if feeds != nil {
+					line += "\t" + strings.Join(feeds.lookup(ip), ",")
+				}
+				fmt.Println(line)
+			}
+		}
+	}
+}
+
+/*
+isNamedPipe checks if fname is a FIFO
+
+Args:
+
+	fname: the file name
+
+Returns:
+
+	true if fname exists and is a named pipe
+*/
+func isNamedPipe(fname string) bool {
+	info, err := os.Stat(fname)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+/*
+followNamedPipe reads one target per line from a FIFO, reopening it each time the writer closes it
+Blank lines and lines starting with # are ignored. This function does not return unless the FIFO can not be read.
+
+Args:
+
+	fname: the FIFO file name
+
+	names: each target is sent to this channel
+*/
+func followNamedPipe(fname string, names chan<- string) error {
+	for {
+		// opening blocks until another process opens the FIFO for writing
+		file, err := os.Open(fname)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			names <- line
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+runNamedPipe enriches every target written to a FIFO, without exiting when a writer closes it or when
+ipinfo.io rate limits the lookups; a rate limit is waited out, see lookupStreamIpInfo
+
+Args:
+
+	fname: the FIFO file name
+
+	cache: the cache to use, or nil
+
+	feeds: the -feeds index, or nil
+
+Returns:
+
+	an error if the FIFO can not be read
+*/
+func runNamedPipe(fname string, cache ipCache, feeds *feedIndex) error {
+	names := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- followNamedPipe(fname, names)
+	}()
+	return enrichStream(names, errCh, false, cache, feeds)
+}
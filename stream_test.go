@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A transport that rate limits the first request and then answers like fakeIpinfo
+type limitOnce struct {
+	requests int
+}
+
+func (l *limitOnce) RoundTrip(r *http.Request) (*http.Response, error) {
+	l.requests++
+	return fakeIpinfo{limited: l.requests == 1}.RoundTrip(r)
+}
+
+func TestLookupStreamIpInfoRetries(t *testing.T) {
+	transport := &limitOnce{}
+	useFakes(t, nil, transport)
+	info := lookupStreamIpInfo("192.0.2.1", nil)
+	if info.ErrMsg != nil || info.Country != "DE" || transport.requests != 2 {
+		t.Errorf("got %+v after %d requests, want DE after 2", info, transport.requests)
+	}
+}
+
+func TestEnrichStreamSurvivesRateLimit(t *testing.T) {
+	useFakes(t, fakeResolver{addr: "192.0.2.1"}, &limitOnce{})
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	// the same as runNamedPipe, with the targets sent directly instead of read from a FIFO
+	names := make(chan string)
+	errCh := make(chan error)
+	done := make(chan error)
+	go func() {
+		done <- enrichStream(names, errCh, false, nil, nil)
+	}()
+	names <- "a.example"
+	names <- "b.example"
+	errCh <- io.EOF
+	if err := <-done; err != io.EOF {
+		t.Errorf("enrichStream returned %v, want the error of the stream", err)
+	}
+	writer.Close()
+	out, _ := io.ReadAll(reader)
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], "AS1 Example") {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+
+tablestyle.go
+
+Support for -table-style, which changes the borders of all tables:
+
+	ascii       borders drawn with + - and |, the default
+	unicode     box drawing characters without an outer border
+	compact     no outer border and columns separated by spaces
+	borderless  left aligned columns without any lines, similar to kubectl
+
+*/
+
+package main
+
+import (
+	"io"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+var tableStyles = []string{"ascii", "unicode", "compact", "borderless"}
+
+// The style of all tables, set with -table-style
+var tableStyle = "ascii"
+
+/*
+newTable creates a table in the style given with -table-style
+
+Args:
+
+	w: where to write the table to
+
+Returns:
+
+	a tablewriter.Table
+*/
+func newTable(w io.Writer) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	switch tableStyle {
+	case "unicode":
+		table.SetBorder(false)
+		table.SetCenterSeparator("┼")
+		table.SetColumnSeparator("│")
+		table.SetRowSeparator("─")
+	case "compact":
+		table.SetBorder(false)
+		table.SetCenterSeparator(" ")
+		table.SetColumnSeparator(" ")
+	case "borderless":
+		table.SetBorder(false)
+		table.SetHeaderLine(false)
+		table.SetCenterSeparator("")
+		table.SetColumnSeparator("")
+		table.SetRowSeparator("")
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetTablePadding("   ")
+		table.SetNoWhiteSpace(true)
+	}
+	return table
+}
@@ -0,0 +1,273 @@
+/*
+
+targets.go
+
+The targets subcommand, which manages named target sets that are looked up with @name arguments:
+
+	ipinfo targets add prod lb1.example.com lb2.example.com
+	ipinfo targets list
+	ipinfo targets rm prod
+	ipinfo lookup @prod
+
+Target sets are stored with the history when -history is a storage DSN, such as redis://host:6379/0,
+postgres://host/dbname or sqlite:///path/to/ipinfo.db, so that they are shared between ipinfo instances;
+otherwise they are stored in targets.json in the user's config directory. Unlike the aliases file, which
+is edited by hand, they are only managed with this subcommand.
+
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisTargetsKey string = redisKeyPrefix + "targets"
+
+// targetSetStore stores the named target sets of the targets subcommand
+type targetSetStore interface {
+	// load returns all target sets, with key=name, value=targets
+	load() (map[string][]string, error)
+	// set replaces the targets of a target set, creating it when it does not exist
+	set(name string, targets []string) error
+	// remove deletes a target set, and reports if it existed
+	remove(name string) (bool, error)
+}
+
+/*
+openTargetSets opens the target sets stored with the history
+
+Args:
+
+	history: the -history value; when it is a file name or empty, the target sets are stored in the user's config directory
+
+Returns:
+
+	a targetSetStore
+*/
+func openTargetSets(history string) (targetSetStore, error) {
+	switch storageScheme(history) {
+	case "redis", "rediss":
+		client, err := connectRedis(history)
+		if err != nil {
+			return nil, err
+		}
+		return redisTargetSets{client: client}, nil
+	case "postgres", "postgresql":
+		pool, err := connectPostgres(history)
+		if err != nil {
+			return nil, err
+		}
+		return pgTargetSets{pool: pool}, nil
+	case "sqlite", "sqlite3":
+		db, err := connectSQLite(history)
+		if err != nil {
+			return nil, err
+		}
+		return sqliteTargetSets{db: db}, nil
+	case "bolt", "bbolt":
+		db, err := connectBolt(history)
+		if err != nil {
+			return nil, err
+		}
+		return boltTargetSets{db: db}, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return fileTargetSets{fname: filepath.Join(dir, "ipinfo", "targets.json")}, nil
+}
+
+/*
+validTargetSetName checks the name of a target set, which is given as @name on the command line
+
+Args:
+
+	name: the name of the target set
+
+Returns:
+
+	an error when the name is empty or contains separators such as : or ,
+*/
+func validTargetSetName(name string) error {
+	if len(name) == 0 || strings.ContainsAny(name, ":,@# \t\r\n") {
+		return fmt.Errorf("invalid target set name: %q; names can not be empty or contain : , @ # or spaces", name)
+	}
+	return nil
+}
+
+/*
+runTargets implements the targets subcommand
+
+	ipinfo targets add <name> <hosts...>
+	ipinfo targets list
+	ipinfo targets rm <name>
+
+Args:
+
+	args: the command line arguments following "targets"
+
+	history: the -history value, which selects where target sets are stored
+*/
+func runTargets(args []string, history string) error {
+	usage := fmt.Errorf("usage: ipinfo targets add <name> <hosts...> | list | rm <name>")
+	if len(args) == 0 {
+		return usage
+	}
+	switch {
+	case args[0] == "add" && len(args) >= 3:
+		if err := validTargetSetName(args[1]); err != nil {
+			return err
+		}
+	case args[0] == "list" && len(args) == 1:
+	case args[0] == "rm" && len(args) == 2:
+	default:
+		return usage
+	}
+	sets, err := openTargetSets(history)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		all, err := sets.load()
+		if err != nil {
+			return err
+		}
+		// when the name already exists, the hosts are added to it
+		targets := all[args[1]]
+		for _, host := range args[2:] {
+			for _, target := range strings.FieldsFunc(host, func(r rune) bool { return r == ',' }) {
+				if target = strings.TrimSpace(target); len(target) > 0 && !stringInSlice(target, targets) {
+					targets = append(targets, target)
+				}
+			}
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("no targets given for %s", args[1])
+		}
+		return sets.set(args[1], targets)
+	case "list":
+		all, err := sets.load()
+		if err != nil {
+			return err
+		}
+		var names []string
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s: %s\n", name, strings.Join(all[name], ", "))
+		}
+		return nil
+	default:
+		found, err := sets.remove(args[1])
+		if err == nil && !found {
+			err = fmt.Errorf("target set not found: %s", args[1])
+		}
+		return err
+	}
+}
+
+// Target sets stored as a JSON object in a file
+type fileTargetSets struct {
+	fname string
+}
+
+// load reads the target sets; a missing file has none
+func (s fileTargetSets) load() (map[string][]string, error) {
+	sets := make(map[string][]string)
+	data, err := os.ReadFile(s.fname)
+	if os.IsNotExist(err) {
+		return sets, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &sets)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.fname, err)
+	}
+	return sets, nil
+}
+
+// save writes all target sets, replacing the file so that it is never left half written
+func (s fileTargetSets) save(sets map[string][]string) error {
+	data, err := json.MarshalIndent(sets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.fname), 0755); err != nil {
+		return err
+	}
+	tmp := s.fname + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.fname)
+}
+
+func (s fileTargetSets) set(name string, targets []string) error {
+	sets, err := s.load()
+	if err != nil {
+		return err
+	}
+	sets[name] = targets
+	return s.save(sets)
+}
+
+func (s fileTargetSets) remove(name string) (bool, error) {
+	sets, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := sets[name]; !ok {
+		return false, nil
+	}
+	delete(sets, name)
+	return true, s.save(sets)
+}
+
+// Target sets stored in a Redis hash, with key=name, value=a JSON array of targets
+type redisTargetSets struct {
+	client *redis.Client
+}
+
+func (s redisTargetSets) load() (map[string][]string, error) {
+	values, err := s.client.HGetAll(context.Background(), redisTargetsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sets := make(map[string][]string, len(values))
+	for name, value := range values {
+		var targets []string
+		if err := json.Unmarshal([]byte(value), &targets); err != nil {
+			return nil, fmt.Errorf("%s[%s]: %w", redisTargetsKey, name, err)
+		}
+		sets[name] = targets
+	}
+	return sets, nil
+}
+
+func (s redisTargetSets) set(name string, targets []string) error {
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), redisTargetsKey, name, data).Err()
+}
+
+func (s redisTargetSets) remove(name string) (bool, error) {
+	removed, err := s.client.HDel(context.Background(), redisTargetsKey, name).Result()
+	return removed > 0, err
+}
@@ -0,0 +1,130 @@
+/*
+
+tls.go
+
+Support for the -tls option, which retrieves the certificate presented by each IP address
+and compares the certificate's subject country with the IP address's geolocation country.
+
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tlsTimeout = 5 * time.Second
+
+// The subject of the certificate presented by an IP address
+type certInfo struct {
+	org     string
+	country string
+	err     error
+}
+
+/*
+fetchCertificate connects to ip:443 using hostname for SNI and returns the subject of the leaf certificate
+The certificate is not verified since it is only inspected
+
+Args:
+
+	ip: the IP address to connect to
+
+	hostname: the host name sent in the TLS handshake
+
+Returns:
+
+	a certInfo struct
+*/
+func fetchCertificate(ip string, hostname string) certInfo {
+	dialer := &net.Dialer{Timeout: tlsTimeout}
+	config := &tls.Config{ServerName: asciiHost(hostname), InsecureSkipVerify: true}
+	if net.ParseIP(hostname) != nil {
+		config.ServerName = ""
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(ip, "443"), config)
+	if err != nil {
+		return certInfo{err: err}
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return certInfo{err: fmt.Errorf("no certificate presented")}
+	}
+	subject := certs[0].Subject
+	return certInfo{org: strings.Join(subject.Organization, ", "), country: strings.Join(subject.Country, ", ")}
+}
+
+/*
+fetchAllCertificates concurrently calls fetchCertificate for each IP address
+
+Args:
+
+	workers: the number of concurrent go routines to execute
+
+	ipAddrs: a slice of IP addresses
+
+	reverseIP: a map where key=IP address, value=hostname
+
+Returns:
+
+	a map where key=IP address, value=certInfo
+*/
+func fetchAllCertificates(workers int, ipAddrs []string, reverseIP map[string]string) map[string]certInfo {
+	certs := make(map[string]certInfo)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, workers)
+	for _, ip := range ipAddrs {
+		wg.Add(1)
+		limit <- true
+		go func(ip string) {
+			defer wg.Done()
+			cert := fetchCertificate(ip, reverseIP[ip])
+			mu.Lock()
+			certs[ip] = cert
+			mu.Unlock()
+			<-limit
+		}(ip)
+	}
+	wg.Wait()
+	return certs
+}
+
+/*
+compareCertificateCountries checks if the certificate's subject country matches the IP address's geolocation country
+Certificates without a subject country, such as most domain validated certificates, are skipped
+
+Args:
+
+	certs: a map as returned by fetchAllCertificates
+
+	ipInfo: a slice of ipInfoResult structs for all resolved IP addresses
+
+	reverseIP: a map where key=IP address, value=hostname
+
+Returns:
+
+	a slice of warning messages, one for each mismatch
+*/
+func compareCertificateCountries(certs map[string]certInfo, ipInfo []ipInfoResult, reverseIP map[string]string) []string {
+	var warnings []string
+	for _, info := range ipInfo {
+		cert, ok := certs[info.Ip]
+		if !ok || cert.err != nil || len(cert.country) == 0 || len(info.Country) == 0 {
+			continue
+		}
+		if !strings.EqualFold(cert.country, info.Country) {
+			warnings = append(warnings, fmt.Sprintf("%s (%s): certificate country %s (%s) differs from IP location country %s", reverseIP[info.Ip], info.Ip, cert.country, cert.org, info.Country))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,283 @@
+/*
+
+tokens.go
+
+Support for -tokens, which spreads the requests to ipinfo.io over several access tokens, so that the
+quota of several free or paid plans can be combined for large batch runs. Each token may have a quota:
+
+	-tokens 1a2b3c4d5e6f7a:50000/month,8b9c0d1e2f3a4b:1000/day
+
+Requests rotate between the tokens that are below their quota. A token that is rate limited by ipinfo.io
+is not used again until the time given by its Retry-After header, or for the rest of the run. The number of requests of each token in the current day or month is
+saved to the user's cache directory, so that quotas are tracked across runs; tokens are only saved as a
+hash.
+
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An ipinfo.io access token
+type apiToken struct {
+	value  string
+	id     string // a hash of the token, used in messages and in the usage file
+	quota  int    // the requests allowed per period, or 0 for no quota
+	period string // day or month
+}
+
+// The requests of a token in a single day or month
+type tokenUsage struct {
+	Period   string `json:"period"` // such as 2024-05 or 2024-05-31
+	Requests int    `json:"requests"`
+}
+
+// The tokens used for ipinfo.io requests
+type tokenPool struct {
+	mu          sync.Mutex
+	tokens      []*apiToken
+	next        int
+	usage       map[string]*tokenUsage // key=token id
+	rateLimited map[string]time.Time   // key=token id, value=until when the token is rate limited by ipinfo.io
+	usageFile   string
+	dirty       bool
+}
+
+// The tokens of -tokens, or nil for unauthenticated requests
+var ipinfoTokens *tokenPool
+
+/*
+periodKey returns the name of the current day or month, which changes when a quota is reset
+
+Args:
+
+	period: day or month
+
+	now: the current time
+
+Returns:
+
+	such as 2024-05-31 for day, or 2024-05 for month
+*/
+func periodKey(period string, now time.Time) string {
+	if period == "day" {
+		return now.UTC().Format("2006-01-02")
+	}
+	return now.UTC().Format("2006-01")
+}
+
+/*
+periodEnd returns when the quota of the current day or month is reset
+
+Args:
+
+	period: day or month
+
+	now: the current time
+
+Returns:
+
+	the start of the next day or month, in UTC
+*/
+func periodEnd(period string, now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	if period == "day" {
+		return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+/*
+parseTokens parses the value given to -tokens and reads the usage file
+
+Args:
+
+	list: comma separated tokens, each optionally followed by a quota, such as token:50000/month or token:1000/day
+
+	usageFile: the file that the usage of each token is kept in; a missing file is not an error
+
+Returns:
+
+	a pointer to a tokenPool struct
+*/
+func parseTokens(list string, usageFile string) (*tokenPool, error) {
+	pool := &tokenPool{usage: make(map[string]*tokenUsage), rateLimited: make(map[string]time.Time), usageFile: usageFile}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		value, quotaStr, hasQuota := strings.Cut(entry, ":")
+		sum := sha256.Sum256([]byte(value))
+		token := &apiToken{value: value, id: hex.EncodeToString(sum[:4]), period: "month"}
+		if hasQuota {
+			count, period, _ := strings.Cut(quotaStr, "/")
+			quota, err := strconv.Atoi(count)
+			if err != nil || quota <= 0 || (len(period) > 0 && period != "day" && period != "month") {
+				return nil, fmt.Errorf("invalid quota for token %s: %s; expected such as 50000/month or 1000/day", token.id, quotaStr)
+			}
+			token.quota = quota
+			if len(period) > 0 {
+				token.period = period
+			}
+		}
+		pool.tokens = append(pool.tokens, token)
+	}
+	if len(pool.tokens) == 0 {
+		return nil, fmt.Errorf("no tokens given")
+	}
+
+	if len(usageFile) > 0 {
+		data, err := os.ReadFile(usageFile)
+		if err == nil {
+			err = json.Unmarshal(data, &pool.usage)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", usageFile, err)
+		}
+	}
+	return pool, nil
+}
+
+/*
+defaultTokenUsageFile returns the file that the usage of each token is kept in
+
+Returns:
+
+	the file name in the user's cache directory, or an empty string when there is none
+*/
+func defaultTokenUsageFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ipinfo", "token-usage.json")
+}
+
+/*
+take selects the token for the next request and counts the request; nil pools give no token
+
+Returns:
+
+	the token, or nil for an unauthenticated request
+
+	a *rateLimitError when every token is rate limited or has reached its quota, with the time until
+	the first token can be used again
+*/
+func (pool *tokenPool) take() (*apiToken, error) {
+	if pool == nil {
+		return nil, nil
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	now := time.Now()
+	var available time.Time // the earliest time a token can be used again
+	for i := 0; i < len(pool.tokens); i++ {
+		token := pool.tokens[(pool.next+i)%len(pool.tokens)]
+		if until, ok := pool.rateLimited[token.id]; ok && (until.IsZero() || now.Before(until)) {
+			if !until.IsZero() && (available.IsZero() || until.Before(available)) {
+				available = until
+			}
+			continue
+		}
+		key := periodKey(token.period, now)
+		usage, ok := pool.usage[token.id]
+		if !ok || usage.Period != key {
+			usage = &tokenUsage{Period: key}
+			pool.usage[token.id] = usage
+		}
+		if token.quota > 0 && usage.Requests >= token.quota {
+			if end := periodEnd(token.period, now); available.IsZero() || end.Before(available) {
+				available = end
+			}
+			continue
+		}
+		usage.Requests++
+		pool.dirty = true
+		pool.next = (pool.next + i + 1) % len(pool.tokens)
+		return token, nil
+	}
+	limited := &rateLimitError{msg: "all ipinfo.io tokens are rate limited or have reached their quota"}
+	if !available.IsZero() {
+		limited.retryAfter = available.Sub(now)
+	}
+	return nil, limited
+}
+
+/*
+rateLimit stops using a token that ipinfo.io rate limited
+
+Args:
+
+	token: the token of the rate limited request
+
+	retryAfter: how long the token is not used, or 0 for the rest of the run
+*/
+func (pool *tokenPool) rateLimit(token *apiToken, retryAfter time.Duration) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	until := time.Time{}
+	if retryAfter > 0 {
+		until = time.Now().Add(retryAfter)
+	}
+	if _, ok := pool.rateLimited[token.id]; !ok {
+		fmt.Fprintf(os.Stderr, "warning: ipinfo.io token %s is rate limited, the remaining tokens are used\n", token.id)
+	}
+	pool.rateLimited[token.id] = until
+}
+
+/*
+save writes the usage of each token to the usage file, when it changed since the last save
+
+Returns:
+
+	an error if the usage file could not be written
+*/
+func (pool *tokenPool) save() error {
+	if pool == nil || len(pool.usageFile) == 0 {
+		return nil
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if !pool.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(pool.usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pool.usageFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(pool.usageFile, data, 0600); err != nil {
+		return err
+	}
+	pool.dirty = false
+	return nil
+}
+
+/*
+saveEvery saves the usage of each token every interval, for long running subcommands; it never returns
+
+Args:
+
+	interval: the time between saves
+*/
+func (pool *tokenPool) saveEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := pool.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "token usage error:", err)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+/*
+
+trend.go
+
+The report subcommand, which turns the runs accumulated with -history into a trend report:
+
+	ipinfo -history results.jsonl report -since 30d
+
+It lists the ASNs and countries first seen in the period, the inputs whose addresses changed most often,
+and how far the distance to each input drifted between the first and the last run of the period.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An ASN or country that was first seen in the period of the report
+type trendFirstSeen struct {
+	Value     string    `json:"value"`
+	FirstSeen time.Time `json:"first_seen"`
+	Inputs    []string  `json:"inputs"`
+}
+
+// How often the addresses of an input changed in the period of the report
+type trendChurn struct {
+	Input     string   `json:"input"`
+	Changes   int      `json:"changes"`
+	Runs      int      `json:"runs"`
+	Addresses []string `json:"addresses"`
+}
+
+// How far the distance to an input drifted in the period of the report
+type trendDrift struct {
+	Input string  `json:"input"`
+	First float64 `json:"first"`
+	Last  float64 `json:"last"`
+	Drift float64 `json:"drift"`
+}
+
+// A trend report of the runs in a period
+type trendReport struct {
+	Since        time.Time        `json:"since"`
+	Runs         int              `json:"runs"`
+	Inputs       int              `json:"inputs"`
+	NewASNs      []trendFirstSeen `json:"new_asns"`
+	NewCountries []trendFirstSeen `json:"new_countries"`
+	Churn        []trendChurn     `json:"churn"`
+	Drift        []trendDrift     `json:"drift"`
+	AverageDrift float64          `json:"average_drift"`
+}
+
+/*
+parseSince parses the period of a report, given in days, weeks or as a Go duration
+
+Args:
+
+	value: such as 30d, 2w or 12h
+
+Returns:
+
+	the length of the period
+*/
+func parseSince(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if count, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid period: %s", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid period: %s; use such as 30d, 2w or 12h", value)
+	}
+	return period, nil
+}
+
+/*
+newValues finds the values of a field that were first seen at or after since
+
+Args:
+
+	records: all records of the history, sorted by time
+
+	since: the start of the period
+
+	field: returns the value of a record, or an empty string to skip it
+
+Returns:
+
+	the new values, in the order they were first seen
+*/
+func newValues(records []historyRecord, since time.Time, field func(historyRecord) string) []trendFirstSeen {
+	found := []trendFirstSeen{}
+	index := make(map[string]int) // key=value, value=position in found, or -1 when seen before the period
+	for _, record := range records {
+		value := field(record)
+		if len(value) == 0 {
+			continue
+		}
+		i, seen := index[value]
+		if !seen {
+			if record.Time.Before(since) {
+				index[value] = -1
+				continue
+			}
+			index[value] = len(found)
+			found = append(found, trendFirstSeen{Value: value, FirstSeen: record.Time})
+			i = index[value]
+		}
+		if i >= 0 && !stringInSlice(record.Input, found[i].Inputs) {
+			found[i].Inputs = append(found[i].Inputs, record.Input)
+		}
+	}
+	return found
+}
+
+/*
+buildTrendReport builds the trend report of a period
+
+Args:
+
+	records: all records of the history, sorted by time
+
+	since: the start of the period
+
+	top: the number of inputs listed for churn and drift
+
+Returns:
+
+	a trendReport struct
+*/
+func buildTrendReport(records []historyRecord, since time.Time, top int) trendReport {
+	report := trendReport{Since: since, Churn: []trendChurn{}, Drift: []trendDrift{}}
+	report.NewASNs = newValues(records, since, func(r historyRecord) string { return asNumber(r.Org) })
+	report.NewCountries = newValues(records, since, func(r historyRecord) string { return strings.ToUpper(r.Country) })
+
+	// the records of each run of each input; the last run before the period is the baseline of the first change
+	type run struct {
+		time      time.Time
+		addresses []string
+		distances []float64
+	}
+	runs := make(map[string][]*run)
+	var inputs []string
+	runTimes := make(map[time.Time]bool)
+	for _, record := range records {
+		inputRuns := runs[record.Input]
+		if n := len(inputRuns); n == 0 || !inputRuns[n-1].time.Equal(record.Time) {
+			if n > 0 && record.Time.Before(since) {
+				inputRuns = inputRuns[n-1:] // only the latest run before the period is kept
+			}
+			inputRuns = append(inputRuns, &run{time: record.Time})
+			runs[record.Input] = inputRuns
+		}
+		current := inputRuns[len(inputRuns)-1]
+		if !stringInSlice(record.Ip, current.addresses) {
+			current.addresses = append(current.addresses, record.Ip)
+		}
+		if record.Distance != nil {
+			current.distances = append(current.distances, *record.Distance)
+		}
+		if !record.Time.Before(since) {
+			if !stringInSlice(record.Input, inputs) {
+				inputs = append(inputs, record.Input)
+			}
+			runTimes[record.Time] = true
+		}
+	}
+	report.Runs, report.Inputs = len(runTimes), len(inputs)
+
+	average := func(values []float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum / float64(len(values))
+	}
+	var totalDrift float64
+	for _, input := range inputs {
+		inputRuns := runs[input]
+		churn := trendChurn{Input: input}
+		var first, last *run
+		for i, r := range inputRuns {
+			sort.Strings(r.addresses)
+			if r.time.Before(since) {
+				continue
+			}
+			churn.Runs++
+			for _, ip := range r.addresses {
+				if !stringInSlice(ip, churn.Addresses) {
+					churn.Addresses = append(churn.Addresses, ip)
+				}
+			}
+			if i > 0 && strings.Join(r.addresses, ",") != strings.Join(inputRuns[i-1].addresses, ",") {
+				churn.Changes++
+			}
+			if len(r.distances) > 0 {
+				if first == nil {
+					first = r
+				}
+				last = r
+			}
+		}
+		if churn.Changes > 0 {
+			sort.Strings(churn.Addresses)
+			report.Churn = append(report.Churn, churn)
+		}
+		if first != nil && first != last {
+			drift := trendDrift{Input: input, First: average(first.distances), Last: average(last.distances)}
+			drift.Drift = drift.Last - drift.First
+			totalDrift += math.Abs(drift.Drift)
+			report.Drift = append(report.Drift, drift)
+		}
+	}
+	if len(report.Drift) > 0 {
+		report.AverageDrift = totalDrift / float64(len(report.Drift))
+	}
+
+	sort.SliceStable(report.Churn, func(a, b int) bool {
+		return report.Churn[a].Changes > report.Churn[b].Changes
+	})
+	sort.SliceStable(report.Drift, func(a, b int) bool {
+		return math.Abs(report.Drift[a].Drift) > math.Abs(report.Drift[b].Drift)
+	})
+	if len(report.Churn) > top {
+		report.Churn = report.Churn[:top]
+	}
+	if len(report.Drift) > top {
+		report.Drift = report.Drift[:top]
+	}
+	return report
+}
+
+/*
+outputTrendReport outputs the trend report as tables
+
+Args:
+
+	report: the report
+
+	wrap: wrap output to better fit the screen width
+*/
+func outputTrendReport(report trendReport, wrap bool) {
+	const layout = "2006-01-02 15:04"
+	fmt.Printf("%d runs of %d inputs since %s\n", report.Runs, report.Inputs, report.Since.Format(layout))
+	render := func(title string, header []string, rows [][]string) {
+		fmt.Printf("\n%s\n", title)
+		if len(rows) == 0 {
+			fmt.Println("none")
+			return
+		}
+		table := newTable(os.Stdout)
+		table.SetHeader(header)
+		table.SetAutoWrapText(wrap)
+		table.AppendBulk(rows)
+		table.Render()
+	}
+	firstSeenRows := func(values []trendFirstSeen) [][]string {
+		var rows [][]string
+		for _, v := range values {
+			rows = append(rows, []string{v.Value, v.FirstSeen.Format(layout), strings.Join(v.Inputs, ", ")})
+		}
+		return rows
+	}
+	render("New ASNs", []string{"ASN", "First Seen", "Inputs"}, firstSeenRows(report.NewASNs))
+	render("New Countries", []string{"Country", "First Seen", "Inputs"}, firstSeenRows(report.NewCountries))
+
+	var rows [][]string
+	for _, c := range report.Churn {
+		rows = append(rows, []string{c.Input, strconv.Itoa(c.Changes), strconv.Itoa(c.Runs), strings.Join(c.Addresses, ", ")})
+	}
+	render("Most Changed Addresses", []string{"Input", "Changes", "Runs", "Addresses"}, rows)
+
+	rows = nil
+	for _, d := range report.Drift {
+		rows = append(rows, []string{d.Input, fmt.Sprintf("%.2f", d.First), fmt.Sprintf("%.2f", d.Last), fmt.Sprintf("%+.2f", d.Drift)})
+	}
+	render("Distance Drift (miles)", []string{"Input", "First", "Last", "Drift"}, rows)
+	if len(report.Drift) > 0 {
+		fmt.Printf("average drift: %.2f miles\n", report.AverageDrift)
+	}
+}
+
+/*
+runTrendReport implements the report subcommand
+
+	ipinfo -history results.jsonl report -since 30d
+
+Args:
+
+	args: the command line arguments following "report"
+
+	fname: the history file name
+
+	wrap: wrap output to better fit the screen width
+*/
+func runTrendReport(args []string, fname string, wrap bool) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	sinceFlag := flags.String("since", "30d", "the period to report on, such as 30d, 2w or 12h")
+	topFlag := flags.Int("top", 10, "the number of inputs listed for address changes and distance drift")
+	jsonFlag := flags.Bool("json", false, "output JSON instead of tables")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(fname) == 0 {
+		return fmt.Errorf("report needs the history file given with -history")
+	}
+	period, err := parseSince(*sinceFlag)
+	if err != nil {
+		return err
+	}
+	history, err := openHistory(fname)
+	if err != nil {
+		return err
+	}
+	records, err := history.load()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(records, func(a, b int) bool {
+		return records[a].Time.Before(records[b].Time)
+	})
+
+	report := buildTrendReport(records, time.Now().Add(-period), *topFlag)
+	if report.Runs == 0 {
+		return fmt.Errorf("no runs recorded in the last %s", *sinceFlag)
+	}
+	if *jsonFlag {
+		return writeJSON(os.Stdout, report)
+	}
+	outputTrendReport(report, wrap)
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+
+upload.go
+
+Support for -s3 and -gcs, which upload the results of each run to a bucket with a timestamped key, such as
+s3://bucket/prefix/ipinfo-20240102T150405Z.json, so that scheduled runs can archive their results without
+local storage. As with zones.go, the provider's command line tool is used (aws and gsutil), so its configured
+credentials are used as is.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// the command line tool and arguments used to copy a file to each kind of bucket
+var uploadCommands = map[string][]string{
+	"s3://": {"aws", "s3", "cp", "--only-show-errors"},
+	"gs://": {"gsutil", "-q", "cp"},
+}
+
+/*
+uploadKey returns the timestamped object a run's results are uploaded to
+
+Args:
+
+	prefix: the bucket and optional prefix, such as s3://bucket/prefix/
+
+	format: the output format of the results: json, ndjson or tsv
+
+	when: the time the run started
+
+Returns:
+
+	the URL of the object
+*/
+func uploadKey(prefix string, format string, when time.Time) string {
+	return fmt.Sprintf("%s/ipinfo-%s.%s", strings.TrimSuffix(prefix, "/"), when.UTC().Format("20060102T150405Z"), format)
+}
+
+/*
+uploadResults uploads the results of a run to an S3 or GCS bucket
+
+Args:
+
+	prefix: the bucket and optional prefix, such as s3://bucket/prefix/ or gs://bucket/prefix/
+
+	rows: the results, as returned by buildRows
+
+	columns: the optional columns of the results
+
+	format: the -format of the run; tsv and ndjson are kept, all others are uploaded as json
+
+	when: the time the run started
+
+Returns:
+
+	the URL of the uploaded object
+*/
+func uploadResults(prefix string, rows []resultRow, columns extraColumns, format string, when time.Time) (string, error) {
+	var command []string
+	for scheme, c := range uploadCommands {
+		if strings.HasPrefix(prefix, scheme) && len(prefix) > len(scheme) {
+			command = c
+		}
+	}
+	if command == nil {
+		return "", fmt.Errorf("invalid bucket: %s; expected s3://bucket/prefix/ or gs://bucket/prefix/", prefix)
+	}
+	if format != "tsv" && format != "ndjson" {
+		format = "json"
+	}
+
+	file, err := os.CreateTemp("", "ipinfo-*."+format)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	err = writeUpload(file, rows, columns, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	key := uploadKey(prefix, format, when)
+	args := append(append([]string{}, command[1:]...), file.Name(), key)
+	if err := runCLI(nil, command[0], args...); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// writeUpload writes the rows in one of the formats uploaded by uploadResults
+func writeUpload(w io.Writer, rows []resultRow, columns extraColumns, format string) error {
+	switch format {
+	case "tsv":
+		return outputTSV(w, rows, columns)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return outputJSON(w, rows)
+	}
+}
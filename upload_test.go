@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadResults(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake aws command is a shell script")
+	}
+	// a fake aws command that records its arguments and keeps a copy of the uploaded file
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncp \"$4\" " + filepath.Join(dir, "uploaded") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "aws"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	rows := []resultRow{{Input: "a.example", ipInfoResult: ipInfoResult{Ip: "192.0.2.1", Country: "DE"}}}
+	when := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	key, err := uploadResults("s3://bucket/prefix/", rows, extraColumns{}, "table", when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "s3://bucket/prefix/ipinfo-20240102T150405Z.json" {
+		t.Errorf("got key %s", key)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if !strings.HasPrefix(string(args), "s3 cp --only-show-errors ") || !strings.HasSuffix(strings.TrimSpace(string(args)), " "+key) {
+		t.Errorf("unexpected aws arguments: %s", args)
+	}
+	uploaded, _ := os.ReadFile(filepath.Join(dir, "uploaded"))
+	if !strings.Contains(string(uploaded), `"192.0.2.1"`) {
+		t.Errorf("unexpected upload: %s", uploaded)
+	}
+
+	if _, err := uploadResults("ftp://bucket/", rows, extraColumns{}, "json", when); err == nil {
+		t.Error("expected an error for an unknown bucket scheme")
+	}
+}
@@ -0,0 +1,127 @@
+/*
+
+www.go
+
+Support for the -www option, which resolves both the apex of a domain and its www. variant
+and reports when the two are served by different infrastructure.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+/*
+wwwVariant returns the www. counterpart of a host name
+
+Args:
+
+	hostname: a host name such as "example.com" or "www.example.com"
+
+Returns:
+
+	"www.example.com" for "example.com" and vice versa; an empty string when hostname is
+	neither an apex domain nor its www. variant
+*/
+func wwwVariant(hostname string) string {
+	if net.ParseIP(hostname) != nil {
+		return ""
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	apex, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil {
+		return ""
+	}
+	if hostname == apex {
+		return "www." + apex
+	}
+	if hostname == "www."+apex {
+		return apex
+	}
+	return ""
+}
+
+/*
+addWwwVariants appends the www. counterpart of each apex domain, and vice versa
+
+Args:
+
+	hostnames: a slice of host names and IP addresses
+
+Returns:
+
+	hostnames along with any missing counterparts
+
+	a map with key=hostname, value=its counterpart, containing each pair once
+*/
+func addWwwVariants(hostnames []string) ([]string, map[string]string) {
+	pairs := make(map[string]string)
+	expanded := append([]string{}, hostnames...)
+	for _, hostname := range hostnames {
+		variant := wwwVariant(hostname)
+		if len(variant) == 0 {
+			continue
+		}
+		if _, ok := pairs[variant]; ok {
+			continue
+		}
+		pairs[hostname] = variant
+		if !stringInSlice(variant, expanded) {
+			expanded = append(expanded, variant)
+		}
+	}
+	return expanded, pairs
+}
+
+/*
+compareWwwVariants checks if each host name and its www. counterpart are served by the same organizations
+
+Args:
+
+	pairs: a map as returned by addWwwVariants
+
+	hostAddrs: the addresses of each host name, as returned by runDNSHosts
+
+	ipInfo: a slice of ipInfoResult structs for all resolved IP addresses
+
+Returns:
+
+	a slice of warning messages, one for each pair served by different organizations
+*/
+func compareWwwVariants(pairs map[string]string, hostAddrs map[string][]string, ipInfo []ipInfoResult) []string {
+	ipOrg := make(map[string]string)
+	for _, info := range ipInfo {
+		ipOrg[info.Ip] = info.Org
+	}
+
+	// reverseIP only keeps the first host name of an IP address shared by both
+	// variants, so the addresses of each host name come from hostAddrs
+	orgs := func(hostname string) []string {
+		var list []string
+		for _, ip := range hostAddrs[hostname] {
+			if org, ok := ipOrg[ip]; ok && !stringInSlice(org, list) {
+				list = append(list, org)
+			}
+		}
+		sort.Strings(list)
+		return list
+	}
+
+	var warnings []string
+	for hostname, variant := range pairs {
+		a, b := orgs(hostname), orgs(variant)
+		if strings.Join(a, "\n") == strings.Join(b, "\n") {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s (%s) and %s (%s) point to different infrastructure", hostname, strings.Join(a, ", "), variant, strings.Join(b, ", ")))
+	}
+	sort.Strings(warnings)
+	return warnings
+}
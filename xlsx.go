@@ -0,0 +1,229 @@
+/*
+
+xlsx.go
+
+Support for -format xlsx, which writes the results as an Excel spreadsheet with a frozen header row.
+Numeric columns are stored as numbers and the location is split into latitude and longitude,
+so that the results can be sorted and charted without reformatting.
+
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The fixed parts of the spreadsheet; only the worksheet depends on the results
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="ipinfo" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`},
+}
+
+/*
+spreadsheetCells converts rows into the header and typed cells of the spreadsheet
+
+Args:
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	columns: the data for optional columns; only columns with data are included
+
+Returns:
+
+	the header
+
+	a slice of spreadsheet rows; each cell is a string, a float64 or nil when unknown
+*/
+func spreadsheetCells(rows []resultRow, columns extraColumns) ([]string, [][]interface{}) {
+	tableHeader, allCells := tableCells(rows, columns)
+	var header []string
+	for _, name := range tableHeader {
+		switch name {
+		case "Loc":
+			header = append(header, "Latitude", "Longitude")
+		case "Distance":
+			header = append(header, "Distance (mi)")
+		case "TTL":
+			header = append(header, "TTL (s)")
+		case "RTT":
+			header = append(header, "RTT (ms)")
+		default:
+			header = append(header, name)
+		}
+	}
+
+	var allRows [][]interface{}
+	for r, cells := range allCells {
+		var row []interface{}
+		for i, cell := range cells {
+			switch tableHeader[i] {
+			case "Loc":
+				if hasLocation(rows[r].Loc) {
+					lat, lon := latlon2coord(rows[r].Loc)
+					row = append(row, lat, lon)
+				} else {
+					row = append(row, nil, nil)
+				}
+			case "Distance":
+				if rows[r].Distance != nil {
+					row = append(row, *rows[r].Distance)
+				} else {
+					row = append(row, nil)
+				}
+			case "TTL", "MX Priority":
+				if number, err := strconv.ParseFloat(cell, 64); err == nil {
+					row = append(row, number)
+				} else {
+					row = append(row, nil)
+				}
+			case "RTT":
+				if rows[r].RTT != nil {
+					row = append(row, *rows[r].RTT)
+				} else {
+					row = append(row, nil)
+				}
+			default:
+				row = append(row, cell)
+			}
+		}
+		allRows = append(allRows, row)
+	}
+	return header, allRows
+}
+
+/*
+columnName converts a zero based column index into a spreadsheet column name, such as 0=A and 26=AA
+
+Args:
+
+	index: the column index
+
+Returns:
+
+	the column name
+*/
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+/*
+writeSheetRow appends a single row to the worksheet XML
+
+Args:
+
+	buf: the worksheet XML
+
+	number: the one based row number
+
+	cells: the cells of the row; each cell is a string, a float64 or nil
+
+	style: the cellXfs index from styles.xml; 1 is bold
+*/
+func writeSheetRow(buf *bytes.Buffer, number int, cells []interface{}, style int) {
+	fmt.Fprintf(buf, `<row r="%d">`, number)
+	for i, cell := range cells {
+		ref := columnName(i) + strconv.Itoa(number)
+		switch value := cell.(type) {
+		case float64:
+			fmt.Fprintf(buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(value, 'f', -1, 64))
+		case string:
+			fmt.Fprintf(buf, `<c r="%s" s="%d" t="inlineStr"><is><t>`, ref, style)
+			xml.EscapeText(buf, []byte(value))
+			buf.WriteString(`</t></is></c>`)
+		}
+	}
+	buf.WriteString(`</row>`)
+}
+
+/*
+outputXLSX outputs all rows as an Excel spreadsheet
+
+Args:
+
+	w: where to write the spreadsheet to
+
+	rows: a slice of resultRow structs as returned by buildRows
+
+	columns: the data for optional columns; only columns with data are output
+
+Returns:
+
+	an error if the spreadsheet could not be written
+*/
+func outputXLSX(w io.Writer, rows []resultRow, columns extraColumns) error {
+	header, allRows := spreadsheetCells(rows, columns)
+
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>
+<sheetData>`)
+	headerCells := make([]interface{}, len(header))
+	for i := range header {
+		headerCells[i] = header[i]
+	}
+	writeSheetRow(&sheet, 1, headerCells, 1)
+	for i, row := range allRows {
+		writeSheetRow(&sheet, i+2, row, 0)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return err
+		}
+	}
+	file, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(sheet.Bytes()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,176 @@
+/*
+
+zones.go
+
+Support for the -route53, -clouddns and -azdns options, which read all A, AAAA and CNAME records
+from a managed DNS zone and geolocate every target. Each provider's command line tool is used
+(aws, gcloud and az), so their configured credentials are used as is.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+/*
+runCLI runs a cloud provider's command line tool and decodes its JSON output
+
+Args:
+
+	v: the value to decode into, or nil to ignore the output
+
+	name: the command to run
+
+	args: the command's arguments
+*/
+func runCLI(v interface{}, name string, args ...string) error {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("%s: %s", name, exitErr.Stderr)
+		}
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(out, v)
+}
+
+// zoneRecords collects the targets of a zone along with the record each one came from
+type zoneRecords struct {
+	targets []string
+	origins [][]string
+}
+
+func (z *zoneRecords) add(name string, rtype string, target string) {
+	target = strings.TrimSuffix(target, ".")
+	if len(target) == 0 {
+		return
+	}
+	z.origins = append(z.origins, []string{strings.TrimSuffix(name, ".") + " " + rtype, target})
+	if !stringInSlice(target, z.targets) {
+		z.targets = append(z.targets, target)
+	}
+}
+
+/*
+route53Targets reads the A, AAAA and CNAME records, including alias targets, of an AWS Route 53 hosted zone
+
+Args:
+
+	zoneID: the hosted zone ID
+
+Returns:
+
+	a zoneRecords struct
+*/
+func route53Targets(zoneID string) (zoneRecords, error) {
+	var z zoneRecords
+	var out struct {
+		ResourceRecordSets []struct {
+			Name            string
+			Type            string
+			ResourceRecords []struct{ Value string }
+			AliasTarget     *struct{ DNSName string }
+		}
+	}
+	if err := runCLI(&out, "aws", "route53", "list-resource-record-sets", "--hosted-zone-id", zoneID, "--output", "json"); err != nil {
+		return z, err
+	}
+	for _, set := range out.ResourceRecordSets {
+		if set.Type != "A" && set.Type != "AAAA" && set.Type != "CNAME" {
+			continue
+		}
+		for _, record := range set.ResourceRecords {
+			z.add(set.Name, set.Type, record.Value)
+		}
+		if set.AliasTarget != nil {
+			z.add(set.Name, set.Type+" alias", set.AliasTarget.DNSName)
+		}
+	}
+	return z, nil
+}
+
+/*
+cloudDNSTargets reads the A, AAAA and CNAME records of a Google Cloud DNS managed zone
+
+Args:
+
+	zone: the managed zone name
+
+Returns:
+
+	a zoneRecords struct
+*/
+func cloudDNSTargets(zone string) (zoneRecords, error) {
+	var z zoneRecords
+	var out []struct {
+		Name    string   `json:"name"`
+		Type    string   `json:"type"`
+		Rrdatas []string `json:"rrdatas"`
+	}
+	if err := runCLI(&out, "gcloud", "dns", "record-sets", "list", "--zone", zone, "--format", "json"); err != nil {
+		return z, err
+	}
+	for _, set := range out {
+		if set.Type != "A" && set.Type != "AAAA" && set.Type != "CNAME" {
+			continue
+		}
+		for _, data := range set.Rrdatas {
+			z.add(set.Name, set.Type, data)
+		}
+	}
+	return z, nil
+}
+
+/*
+azureDNSTargets reads the A, AAAA and CNAME records of an Azure DNS zone
+
+Args:
+
+	zone: the resource group and zone name in this format: resource-group/zone
+
+Returns:
+
+	a zoneRecords struct
+*/
+func azureDNSTargets(zone string) (zoneRecords, error) {
+	var z zoneRecords
+	slots := strings.SplitN(zone, "/", 2)
+	if len(slots) != 2 {
+		return z, fmt.Errorf("expected resource-group/zone: %s", zone)
+	}
+	var out []struct {
+		Fqdn     string `json:"fqdn"`
+		ARecords []struct {
+			Ipv4Address string `json:"ipv4Address"`
+		} `json:"aRecords"`
+		AaaaRecords []struct {
+			Ipv6Address string `json:"ipv6Address"`
+		} `json:"aaaaRecords"`
+		CnameRecord *struct {
+			Cname string `json:"cname"`
+		} `json:"cnameRecord"`
+	}
+	if err := runCLI(&out, "az", "network", "dns", "record-set", "list", "-g", slots[0], "-z", slots[1], "-o", "json"); err != nil {
+		return z, err
+	}
+	for _, set := range out {
+		for _, record := range set.ARecords {
+			z.add(set.Fqdn, "A", record.Ipv4Address)
+		}
+		for _, record := range set.AaaaRecords {
+			z.add(set.Fqdn, "AAAA", record.Ipv6Address)
+		}
+		if set.CnameRecord != nil {
+			z.add(set.Fqdn, "CNAME", set.CnameRecord.Cname)
+		}
+	}
+	return z, nil
+}